@@ -4,7 +4,9 @@ import (
 	"client/internal/security"
 	"context"
 	"errors"
+	"flag"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -13,21 +15,63 @@ import (
 	"time"
 
 	grpcprometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	"client/internal/config"
+	"client/internal/logging"
+	monitoringpb "client/internal/pb/monitoring"
 	"client/internal/service"
+	"client/internal/telemetry"
 )
 
+// streamRetryDelay is how long the client waits before reopening a failed stream.
+const streamRetryDelay = 5 * time.Second
+
 func main() {
-	cfg := config.LoadConfig()
+	cfg, printConfig, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("[CONFIG] %v", err)
+		}
+		return
+	}
+
 	ctx := context.Background()
 
 	tickerCtx, cancelTickers := context.WithCancel(context.Background())
 	defer cancelTickers()
 
-	tp, err := setupOpenTelemetry(ctx, cfg)
+	sampler := telemetry.NewSampler()
+	if err := applyLive(cfg, sampler); err != nil {
+		log.Fatalf("[CONFIG] %v", err)
+	}
+	// Sampler overrides for methods the client never calls are logged.
+	var methods []string
+	for _, desc := range []grpc.ServiceDesc{monitoringpb.MonitoringService_ServiceDesc, healthpb.Health_ServiceDesc} {
+		for _, m := range desc.Methods {
+			methods = append(methods, "/"+desc.ServiceName+"/"+m.MethodName)
+		}
+		for _, st := range desc.Streams {
+			methods = append(methods, "/"+desc.ServiceName+"/"+st.StreamName)
+		}
+	}
+	sampler.SetMethods(methods)
+	res, err := telemetry.NewResource(ctx, cfg, "grpc-client")
+	if err != nil {
+		log.Fatalf("failed to set up tracer: %v", err)
+	}
+	tp, err := setupOpenTelemetry(ctx, cfg, res, sampler)
 	if err != nil {
 		log.Fatalf("failed to set up tracer: %v", err)
 	}
@@ -35,40 +79,71 @@ func main() {
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	// SIGHUP reloads the configuration; see reloadConfig.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
-	creds, err := security.LoadClientTLSCredentials(cfg)
+	creds, err := security.TransportCredentials(tickerCtx, cfg)
 	if err != nil {
 		log.Fatalf("cannot load client TLS credentials: %v", err)
 	}
 
-	// Dial options:
-	// - WithStatsHandler(otelgrpc.NewClientHandler()) → for tracing outgoing RPCs
-	// - grpcprometheus interceptors → for Prometheus metrics
-	otelClientHandler := otelgrpc.NewClientHandler()
-	dialOpts := []grpc.DialOption{
-		// mTLS
-		grpc.WithTransportCredentials(creds),
-		// OpenTelemetry interceptor
-		grpc.WithStatsHandler(otelClientHandler),
-		// Prometheus interceptors
-		grpc.WithUnaryInterceptor(grpcprometheus.UnaryClientInterceptor),
-		grpc.WithStreamInterceptor(grpcprometheus.StreamClientInterceptor),
+	// All client metrics live in their own registry, served on /metrics.
+	// Records unary latency as well as how long Watch streams stay open.
+	grpcprometheus.EnableClientHandlingTimeHistogram()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		grpcprometheus.DefaultClientMetrics,
+	)
+	security.RegisterMetrics(reg)
+	telemetry.RegisterMetrics(reg)
+	telemetry.RegisterTargetInfo(reg, res)
+	reg.MustRegister(configReloads, configRestartRequired)
+	mp, err := setupMeterProvider(ctx, cfg, res, reg)
+	if err != nil {
+		log.Fatalf("failed to set up meter provider: %v", err)
 	}
+	defer func() { _ = mp.Shutdown(ctx) }()
+	metricOpts := []service.Option{service.WithRegisterer(reg)}
 
-	clientSvc, err := service.NewClientService(cfg.GRPCServerAddress, dialOpts...)
+	clientSvc, err := service.NewClientService(cfg.GRPCServerAddress,
+		service.WithDialOptions(dialOptions(creds)...), service.WithRegisterer(reg))
 	if err != nil {
 		log.Fatalf("failed to create ClientService: %v", err)
 	}
 	defer func() {
 		if err := clientSvc.Close(); err != nil {
-			log.Printf("error closing gRPC client connection: %v", err)
+			log.Printf("error closing gRPC client connection to %s: %v", cfg.GRPCServerAddress, err)
 		}
 	}()
 
+	// Probes come from the probe file when one is configured, otherwise
+	// "ping" every 15 seconds and "wrong" every 2 minutes.
+	probes, err := loadProbes(tickerCtx, cfg, clientSvc, metricOpts)
+	if err != nil {
+		log.Fatalf("failed to set up probes: %v", err)
+	}
+	defer func() { probes.close() }()
+	scheduler := service.NewScheduler(append(metricOpts, service.WithFailureThreshold(cfg.ProbeFailureThreshold))...)
+	for _, p := range probes.probes {
+		if err := scheduler.Register(p); err != nil {
+			log.Fatalf("failed to register probe: %v", err)
+		}
+	}
+
+	// /healthz fails when every probe keeps failing, /readyz as soon as one
+	// does (or before every probe has run once).
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+	mux.Handle("/healthz", healthHandler(scheduler.Live))
+	mux.Handle("/readyz", healthHandler(scheduler.Ready))
+
 	metricAddr := ":" + cfg.MetricsPort
 	httpSrv := &http.Server{
 		Addr:    metricAddr,
-		Handler: promhttp.Handler(),
+		Handler: mux,
 	}
 	go func() {
 		log.Printf("[METRICS] listening on %s", metricAddr)
@@ -77,45 +152,49 @@ func main() {
 		}
 	}()
 
-	// Goroutine: send "ping" every 15 seconds
-	go func() {
-		ticker := time.NewTicker(15 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-tickerCtx.Done():
-				return
-			case <-ticker.C:
-				msg, err := clientSvc.SendPing(context.Background())
-				if err != nil {
-					log.Printf("[PING] error sending ping: %v", err)
-				} else {
-					log.Printf("[PING] received response: %s", msg)
-				}
-			}
-		}
-	}()
+	go scheduler.Run(tickerCtx)
 
-	// 9) Goroutine: send "wrong" every 2 minutes
-	go func() {
-		ticker := time.NewTicker(2 * time.Minute)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-tickerCtx.Done():
-				return
-			case <-ticker.C:
-				err := clientSvc.SendWrong(context.Background())
+	// Goroutine: keep a Watch stream open, reconnecting after it fails
+	go keepStreaming(tickerCtx, "[WATCH]", func(ctx context.Context) error {
+		return clientSvc.Watch(ctx, cfg.WatchInterval, func(hb *monitoringpb.Heartbeat) {
+			logging.Debugf("[WATCH] heartbeat #%d sent at %s",
+				hb.GetSequence(), hb.GetSentAt().AsTime().Format(time.RFC3339))
+		})
+	})
+
+	// Goroutine: keep a PingStream open; latencies are exported as histograms
+	go keepStreaming(tickerCtx, "[PING_STREAM]", func(ctx context.Context) error {
+		return clientSvc.PingStream(ctx, cfg.PingStreamInterval, nil)
+	})
+
+wait:
+	for {
+		select {
+		case <-hup:
+			log.Println("[MAIN] SIGHUP received, reloading configuration")
+			// Probe counters survive the reload; only the probes change.
+			reloadConfig(cfg, func(next *config.Config) error {
+				nextProbes, err := loadProbes(tickerCtx, next, clientSvc, metricOpts)
 				if err != nil {
-					log.Printf("[WRONG] unexpected error: %v", err)
-				} else {
-					log.Printf("[WRONG] server correctly returned InvalidArgument")
+					return err
+				}
+				if err := applyLive(next, sampler); err != nil {
+					nextProbes.close()
+					return err
 				}
-			}
+				if err := scheduler.Replace(nextProbes.probes); err != nil {
+					nextProbes.close()
+					return errors.Join(err, applyLive(cfg, sampler))
+				}
+				scheduler.SetFailureThreshold(next.ProbeFailureThreshold)
+				probes.close()
+				probes = nextProbes
+				return nil
+			})
+		case <-stop:
+			break wait
 		}
-	}()
-
-	<-stop
+	}
 	log.Println("[MAIN] shutdown signal received, stopping all goroutines...")
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -129,3 +208,51 @@ func main() {
 	cancelTickers()
 	log.Println("[MAIN] all goroutines signaled to stop; exiting")
 }
+
+// keepStreaming runs open until ctx is cancelled, reopening the stream after
+// streamRetryDelay whenever it ends on its own.
+func keepStreaming(ctx context.Context, tag string, open func(context.Context) error) {
+	for {
+		err := open(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("%s stream ended: %v; reconnecting in %s", tag, err, streamRetryDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(streamRetryDelay):
+		}
+	}
+}
+
+// healthHandler answers 200 "ok" while check passes and 503 with the
+// check's error otherwise.
+func healthHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok\n")
+	})
+}
+
+// dialOptions returns the options every target connection is dialled with:
+// - WithStatsHandler(otelgrpc.NewClientHandler()) → for tracing outgoing RPCs
+// - grpcprometheus interceptors → for Prometheus metrics
+func dialOptions(creds credentials.TransportCredentials) []grpc.DialOption {
+	// Message events let Jaeger show every message sent/received on Watch streams.
+	otelClientHandler := otelgrpc.NewClientHandler(
+		otelgrpc.WithMessageEvents(otelgrpc.ReceivedEvents, otelgrpc.SentEvents),
+	)
+	return []grpc.DialOption{
+		// Plaintext, TLS or mTLS, depending on TRANSPORT_MODE
+		grpc.WithTransportCredentials(creds),
+		// OpenTelemetry interceptor
+		grpc.WithStatsHandler(otelClientHandler),
+		// Prometheus interceptors
+		grpc.WithUnaryInterceptor(grpcprometheus.UnaryClientInterceptor),
+		grpc.WithStreamInterceptor(grpcprometheus.StreamClientInterceptor),
+	}
+}
@@ -2,49 +2,50 @@ package main
 
 import (
 	"client/internal/config"
+	"client/internal/telemetry"
 	"context"
-	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
-func setupOpenTelemetry(ctx context.Context, cfg *config.Config) (*trace.TracerProvider, error) {
-	endpoint := cfg.OTLPCollectorEndpoint
-
-	conn, err := grpc.NewClient(
-		endpoint,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+// setupOpenTelemetry installs the global tracer provider, which samples
+// spans with sampler and describes them with res. Spans are only exported
+// if cfg selects an exporter.
+func setupOpenTelemetry(ctx context.Context, cfg *config.Config, res *resource.Resource, sampler trace.Sampler) (*trace.TracerProvider, error) {
+	exp, _, err := telemetry.NewExporter(ctx, cfg)
 	if err != nil {
-		return nil, fmt.Errorf("dial OTLP/gRPC endpoint %q: %w", endpoint, err)
+		return nil, err
 	}
 
-	exp, err := otlptracegrpc.New(
-		ctx,
-		otlptracegrpc.WithGRPCConn(conn),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create OTLP/gRPC exporter: %w", err)
+	opts := []trace.TracerProviderOption{
+		trace.WithResource(res),
+		trace.WithSampler(sampler),
 	}
+	if exp != nil {
+		opts = append(opts, trace.WithSpanProcessor(telemetry.NewSpanProcessor(exp)))
+	}
+	tp := trace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
 
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String("grpc-client"),
-		),
-	)
+// setupMeterProvider installs the global meter provider, which otelgrpc
+// records rpc.client.duration and the other RPC metrics with. Measurements
+// taken within a sampled span carry its trace ID as an exemplar.
+func setupMeterProvider(ctx context.Context, cfg *config.Config, res *resource.Resource, reg prometheus.Registerer) (*metric.MeterProvider, error) {
+	readers, err := telemetry.NewMetricReaders(ctx, cfg, reg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create resource: %w", err)
+		return nil, err
 	}
 
-	tp := trace.NewTracerProvider(
-		trace.WithBatcher(exp),
-		trace.WithResource(res),
-	)
-	otel.SetTracerProvider(tp)
-	return tp, nil
+	opts := []metric.Option{metric.WithResource(res)}
+	for _, r := range readers {
+		opts = append(opts, metric.WithReader(r))
+	}
+	mp := metric.NewMeterProvider(opts...)
+	otel.SetMeterProvider(mp)
+	return mp, nil
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"client/internal/config"
+	"client/internal/security"
+	"client/internal/service"
+)
+
+// probeSet holds the probes built from one configuration and the
+// connections to the targets they check besides the default server.
+type probeSet struct {
+	probes   []service.Probe
+	services map[string]*service.ClientService
+	// cancel stops the TLS file reloading of services.
+	cancel context.CancelFunc
+}
+
+// loadProbes builds the probes for cfg; see buildProbes. Targets other
+// than the default server are dialled anew, so a reloaded probe file can
+// change their TLS identity.
+func loadProbes(
+	ctx context.Context,
+	cfg *config.Config,
+	defaultSvc *service.ClientService,
+	metricOpts []service.Option,
+) (*probeSet, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	services := map[string]*service.ClientService{cfg.GRPCServerAddress: defaultSvc}
+	probes, err := buildProbes(ctx, cfg, services, metricOpts)
+	delete(services, cfg.GRPCServerAddress)
+	set := &probeSet{probes: probes, services: services, cancel: cancel}
+	if err != nil {
+		set.close()
+		return nil, err
+	}
+	return set, nil
+}
+
+// close closes the connections to the extra targets.
+func (s *probeSet) close() {
+	s.cancel()
+	for target, svc := range s.services {
+		if err := svc.Close(); err != nil {
+			log.Printf("error closing gRPC client connection to %s: %v", target, err)
+		}
+	}
+}
+
+// buildProbes returns the probes to schedule. Without a probe file it falls
+// back to the built-in ping/wrong probes against the default service.
+// Services dialled for extra targets use metricOpts and are added to
+// services so the caller can close them.
+func buildProbes(
+	ctx context.Context,
+	cfg *config.Config,
+	services map[string]*service.ClientService,
+	metricOpts []service.Option,
+) ([]service.Probe, error) {
+	defaultSvc := services[cfg.GRPCServerAddress]
+	if cfg.ProbesFile == "" {
+		return []service.Probe{
+			defaultSvc.PingProbe(15*time.Second, config.DefaultProbeDeadline),
+			defaultSvc.WrongProbe(2*time.Minute, config.DefaultProbeDeadline),
+		}, nil
+	}
+
+	file, err := config.LoadProbeFile(cfg.ProbesFile, cfg.GRPCServerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	probes := make([]service.Probe, 0, len(file.Probes))
+	for _, def := range file.Probes {
+		svc, ok := services[def.Target]
+		if !ok {
+			svc, err = newTargetService(ctx, cfg, file, def.Target, metricOpts)
+			if err != nil {
+				return nil, fmt.Errorf("probe %q: %w", def.Name, err)
+			}
+			services[def.Target] = svc
+		}
+
+		switch def.Method {
+		case config.MethodWatch:
+			probes = append(probes, svc.WatchProbe(def.Name, def.Interval, def.Deadline, def.Code))
+		case config.MethodHealthCheck:
+			probes = append(probes, svc.HealthCheckProbe(def.Name, def.Service, def.Interval, def.Deadline, def.Code))
+		case config.MethodHealthWatch:
+			probes = append(probes, svc.HealthWatchProbe(def.Name, def.Service, def.Interval, def.Deadline, def.Code))
+		default:
+			probes = append(probes, svc.MonitoringProbe(def.Name, def.Message, def.Interval, def.Deadline, def.Code, def.Pattern))
+		}
+	}
+	return probes, nil
+}
+
+// newTargetService dials address with its own connection, using the TLS
+// identity declared for it in the probe file or the client default. The TLS
+// files are reloaded until ctx is cancelled.
+func newTargetService(
+	ctx context.Context,
+	cfg *config.Config,
+	file *config.ProbeFile,
+	address string,
+	metricOpts []service.Option,
+) (*service.ClientService, error) {
+	tlsCfg := cfg
+	if t, ok := file.Target(address); ok {
+		tlsCfg = t.TLSConfig(cfg)
+	}
+
+	creds, err := security.TransportCredentials(ctx, tlsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: %w", address, err)
+	}
+	opts := append([]service.Option{service.WithDialOptions(dialOptions(creds)...)}, metricOpts...)
+	return service.NewClientService(address, opts...)
+}
@@ -0,0 +1,353 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// setting binds a configuration key to a field of Config. The key is used
+// as is in config files, upper-cased as an environment variable and with
+// dashes as a flag: tls_cert_file, TLS_CERT_FILE and --tls-cert-file.
+type setting struct {
+	key string
+	// value is a *string, *int, *float64, *time.Duration, *[]string or
+	// *Headers.
+	value any
+	usage string
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"grpc_server_address", &c.GRPCServerAddress, "host:port of the server"},
+		{"metrics_port", &c.MetricsPort, "port of the /metrics, /healthz and /readyz HTTP server"},
+		{"transport_mode", &c.TransportMode, "plaintext, tls or mtls"},
+		{"tls_cert_file", &c.TLSCertFile, "client certificate"},
+		{"tls_key_file", &c.TLSKeyFile, "client private key"},
+		{"tls_ca_file", &c.TLSCAFile, "CA bundle for the server certificate"},
+		{"tls_server_name", &c.TLSServerName, "name checked against the server certificate; empty means the dialled host"},
+		{"tls_crl_file", &c.TLSCRLFile, "CRLs checked against the server certificate"},
+		{"tls_reload_interval", &c.TLSReloadInterval, "how often TLS files are checked for changes; 0 disables it"},
+		{"tls_policy", &c.TLSPolicy, "default, or strict for TLS 1.3 only"},
+		{"tls_min_version", &c.TLSMinVersion, "minimum TLS version: 1.2 or 1.3"},
+		{"tls_max_version", &c.TLSMaxVersion, "maximum TLS version: 1.2 or 1.3"},
+		{"tls_cipher_suites", &c.TLSCipherSuites, "comma-separated TLS 1.2 cipher suites"},
+		{"tls_curves", &c.TLSCurves, "comma-separated key exchange groups, in order of preference"},
+		{"tls_alpn", &c.TLSALPN, "comma-separated ALPN protocols, including h2"},
+		{"spiffe_endpoint_socket", &c.SPIFFEEndpointSocket, "SPIFFE Workload API address"},
+		{"spiffe_svid_dir", &c.SPIFFESVIDDir, "directory holding svid.pem, svid_key.pem and svid_bundle.pem"},
+		{"spiffe_allowed_ids", &c.SPIFFEAllowedIDs, "comma-separated SPIFFE IDs accepted from the server"},
+		{"probes_file", &c.ProbesFile, "YAML or JSON probe definitions"},
+		{"probe_failure_threshold", &c.ProbeFailureThreshold, "consecutive failed runs that make a probe unhealthy"},
+		{"watch_interval", &c.WatchInterval, "interval of the Watch stream"},
+		{"ping_stream_interval", &c.PingStreamInterval, "interval of the PingStream stream"},
+		{"deployment_environment", &c.DeploymentEnvironment, "deployment.environment reported with traces and in target_info"},
+		{"service_instance_id", &c.ServiceInstanceID, "service.instance.id reported with traces and in target_info; empty means a random UUID"},
+		{"traces_exporter", &c.TracesExporter, "none, stdout or otlp; empty means otlp if otlp_collector_endpoint is set, none otherwise"},
+		{"traces_file", &c.TracesFile, "file the stdout exporter appends JSON spans to instead of standard output"},
+		{"metrics_exporter", &c.MetricsExporter, "comma-separated exporters of OpenTelemetry metrics: prometheus, otlp or none"},
+		{"metrics_export_interval", &c.MetricsExportInterval, "how often the otlp metrics exporter pushes metrics"},
+		{"otlp_collector_endpoint", &c.OTLPCollectorEndpoint, "OTLP endpoint for traces and metrics: host:port or an http:// or https:// URL"},
+		{"otlp_protocol", &c.OTLPProtocol, "grpc or http/protobuf"},
+		{"otlp_ca_file", &c.OTLPCAFile, "CA bundle for the collector certificate; turns on TLS"},
+		{"otlp_cert_file", &c.OTLPCertFile, "client certificate for the collector; turns on TLS"},
+		{"otlp_key_file", &c.OTLPKeyFile, "client private key for the collector"},
+		{"otlp_headers", &c.OTLPHeaders, "comma-separated name=value headers sent to the collector, values percent-encoded"},
+		{"otlp_compression", &c.OTLPCompression, "none or gzip"},
+		{"otlp_timeout", &c.OTLPTimeout, "timeout of each export to the collector"},
+		{"traces_sampler", &c.TracesSampler, "always_on, always_off, traceidratio, or one of them prefixed with parentbased_"},
+		{"trace_sample_ratio", &c.TraceSampleRatio, "fraction of new traces sampled by the traceidratio samplers, from 0 to 1"},
+		{"traces_sampler_overrides", &c.TracesSamplerOverrides, "comma-separated method=sampler pairs; sampler is always_on, always_off, errors or a ratio"},
+		{"log_level", &c.LogLevel, "info, or debug for a line per handshake, probe run and heartbeat"},
+	}
+}
+
+func (s setting) env() string  { return strings.ToUpper(s.key) }
+func (s setting) flag() string { return strings.ReplaceAll(s.key, "_", "-") }
+
+// set parses v into the field of s.
+func (s setting) set(v string) error {
+	switch p := s.value.(type) {
+	case *string:
+		*p = v
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		*p = n
+	case *float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		*p = f
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 1m", v)
+		}
+		*p = d
+	case *[]string:
+		*p = splitList(v)
+	case *Headers:
+		*p = splitList(v)
+	}
+	return nil
+}
+
+// flagValue holds a command-line value until Load applies it after the
+// file and the environment. String shows the default in -h.
+type flagValue struct {
+	setting setting
+	def     string
+	value   string
+	given   bool
+}
+
+func (f *flagValue) String() string { return f.def }
+
+func (f *flagValue) Set(v string) error {
+	f.value, f.given = v, true
+	return nil
+}
+
+// Load builds the configuration from, in increasing order of priority, the
+// defaults, the YAML or TOML file named by --config or CONFIG_FILE,
+// environment variables and command-line flags, and validates it. Every
+// problem is reported at once. printConfig is set by --print-config.
+// A -h flag returns flag.ErrHelp.
+func Load(args []string) (cfg *Config, printConfig bool, err error) {
+	cfg = Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file (env CONFIG_FILE)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration, with secrets redacted, and exit")
+	var flags []*flagValue
+	for _, s := range settings {
+		f := &flagValue{setting: s, def: s.text()}
+		fs.Var(f, s.flag(), fmt.Sprintf("%s (env %s)", s.usage, s.env()))
+		flags = append(flags, f)
+		if s.key == "probes_file" {
+			// -probes predates the loader.
+			fs.Var(f, "probes", "same as --probes-file")
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	var errs []error
+	if *configFile != "" {
+		errs = append(errs, loadFile(*configFile, settings))
+	}
+	for _, s := range settings {
+		if v := os.Getenv(s.env()); v != "" {
+			if err := s.set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env(), err))
+			}
+		}
+	}
+	for _, f := range flags {
+		if !f.given {
+			continue
+		}
+		if err := f.setting.set(f.value); err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %w", f.setting.flag(), err))
+		}
+	}
+	errs = append(errs, cfg.Validate())
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, false, fmt.Errorf("config: invalid configuration:\n%w", err)
+	}
+	return cfg, printConfig, nil
+}
+
+// Reload loads the configuration again from args, the config file and
+// the environment, and returns a copy of c with the settings named in live
+// taken from it. It also returns the live settings that changed and the
+// other settings that changed, which only take effect after a restart. c
+// itself is left as is; the caller replaces it once the new settings are
+// in effect.
+func (c *Config) Reload(args []string, live ...string) (next *Config, applied, restart []string, err error) {
+	loaded, _, err := Load(args)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	next = new(Config)
+	*next = *c
+	loadedSettings := loaded.settings()
+	for i, s := range next.settings() {
+		v := loadedSettings[i].text()
+		if s.text() == v {
+			continue
+		}
+		if !slices.Contains(live, s.key) {
+			restart = append(restart, s.key)
+			continue
+		}
+		// v was produced by text, so it parses.
+		_ = s.set(v)
+		applied = append(applied, s.key)
+	}
+	return next, applied, restart, nil
+}
+
+// loadFile applies the settings in a .yaml, .yml or .toml file. Unknown
+// keys are errors, so a typo does not silently keep the default.
+func loadFile(path string, settings []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	var values map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("%s: unsupported config file type %q, want .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var errs []error
+	for _, key := range keys {
+		i := slices.IndexFunc(settings, func(s setting) bool { return s.key == key })
+		if i < 0 {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, key))
+			continue
+		}
+		if err := settings[i].set(fileValue(values[key])); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fileValue turns a decoded YAML or TOML value into the string form used
+// by environment variables and flags.
+func fileValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	case map[string]any:
+		// A table of headers.
+		items := make([]string, 0, len(v))
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			items = append(items, name+"="+fmt.Sprint(v[name]))
+		}
+		return strings.Join(items, ",")
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// Print writes c as a YAML config file. Passwords in URLs and header
+// values are redacted.
+func (c *Config) Print(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range c.settings() {
+		var value yaml.Node
+		if err := value.Encode(s.redacted()); err != nil {
+			return err
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: s.key}, &value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// text returns the value of s as it would be written in an environment
+// variable.
+func (s setting) text() string {
+	switch p := s.value.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *float64:
+		return strconv.FormatFloat(*p, 'g', -1, 64)
+	case *time.Duration:
+		return p.String()
+	case *[]string:
+		return strings.Join(*p, ",")
+	case *Headers:
+		return strings.Join(*p, ",")
+	}
+	return ""
+}
+
+// redacted returns the value of s safe to print.
+func (s setting) redacted() any {
+	switch p := s.value.(type) {
+	case *string:
+		if u, err := url.Parse(*p); err == nil && u.User != nil {
+			return u.Redacted()
+		}
+		return *p
+	case *int:
+		return *p
+	case *float64:
+		return *p
+	case *time.Duration:
+		return p.String()
+	case *[]string:
+		return *p
+	case *Headers:
+		masked := make([]string, len(*p))
+		for i, pair := range *p {
+			name, _, _ := strings.Cut(pair, "=")
+			masked[i] = name + "=xxxxx"
+		}
+		return masked
+	}
+	return nil
+}
+
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultProbeDeadline is used for probes that do not set a deadline.
+const DefaultProbeDeadline = 10 * time.Second
+
+// Probe methods understood by the client.
+const (
+	MethodMonitoring = "Monitoring"
+	MethodWatch      = "Watch"
+	// MethodHealthCheck and MethodHealthWatch call the standard
+	// grpc.health.v1.Health service for the probe's Service.
+	MethodHealthCheck = "HealthCheck"
+	MethodHealthWatch = "HealthWatch"
+)
+
+// ProbeFile is the layout of the file pointed to by PROBES_FILE / -probes.
+// JSON is accepted too, since it is valid YAML.
+type ProbeFile struct {
+	Targets []TargetDefinition `yaml:"targets"`
+	Probes  []ProbeDefinition  `yaml:"probes"`
+}
+
+// TargetDefinition declares a server to probe with its own connection and,
+// optionally, its own TLS identity. Empty TLS fields fall back to the
+// TLS_* settings of the client.
+type TargetDefinition struct {
+	Address       string `yaml:"address"`
+	TLSCertFile   string `yaml:"tls_cert_file"`
+	TLSKeyFile    string `yaml:"tls_key_file"`
+	TLSCAFile     string `yaml:"tls_ca_file"`
+	TLSServerName string `yaml:"tls_server_name"`
+	TLSCRLFile    string `yaml:"tls_crl_file"`
+	// SPIFFEID is the SPIFFE ID the target must present when SPIFFE is
+	// enabled; it replaces SPIFFE_ALLOWED_IDS for this target.
+	SPIFFEID string `yaml:"spiffe_id"`
+}
+
+// TLSConfig returns a copy of base with the target's TLS overrides applied.
+func (t TargetDefinition) TLSConfig(base *Config) *Config {
+	cfg := *base
+	if t.TLSCertFile != "" {
+		cfg.TLSCertFile = t.TLSCertFile
+	}
+	if t.TLSKeyFile != "" {
+		cfg.TLSKeyFile = t.TLSKeyFile
+	}
+	if t.TLSCAFile != "" {
+		cfg.TLSCAFile = t.TLSCAFile
+	}
+	if t.TLSServerName != "" {
+		cfg.TLSServerName = t.TLSServerName
+	}
+	if t.TLSCRLFile != "" {
+		cfg.TLSCRLFile = t.TLSCRLFile
+	}
+	if t.SPIFFEID != "" {
+		cfg.SPIFFEAllowedIDs = []string{t.SPIFFEID}
+	}
+	return &cfg
+}
+
+func (t TargetDefinition) overridesTLS() bool {
+	return t.TLSCertFile != "" || t.TLSKeyFile != "" || t.TLSCAFile != "" ||
+		t.TLSServerName != "" || t.TLSCRLFile != "" || t.SPIFFEID != ""
+}
+
+// ProbeDefinition declares one synthetic check.
+type ProbeDefinition struct {
+	Name string `yaml:"name"`
+	// Target is the server address. When empty, the probe runs against
+	// every declared target, or GRPC_SERVER_ADDRESS if there are none.
+	Target string `yaml:"target"`
+	// Method is the MonitoringService RPC to call, or HealthCheck /
+	// HealthWatch for the standard health service; defaults to Monitoring.
+	Method string `yaml:"method"`
+	// Service is the name checked by the health methods; empty means the
+	// server as a whole.
+	Service string `yaml:"service"`
+	// Message is sent as the client_request message of a Monitoring call.
+	Message  string        `yaml:"message"`
+	Interval time.Duration `yaml:"interval"`
+	Deadline time.Duration `yaml:"deadline"`
+	// ExpectedCode is a gRPC code name such as "OK" or "InvalidArgument"
+	// (INVALID_ARGUMENT and numeric codes work too); defaults to OK.
+	ExpectedCode string `yaml:"expected_code"`
+	// ExpectedResponse is a regular expression matched against the reply
+	// message, or against the status message when the RPC returns an error.
+	ExpectedResponse string `yaml:"expected_response"`
+
+	// Filled in by LoadProbeFile from the fields above.
+	Code    codes.Code     `yaml:"-"`
+	Pattern *regexp.Regexp `yaml:"-"`
+}
+
+// LoadProbeFile reads and validates a probe definition file. Probes
+// without a target are expanded into one probe per declared target, or
+// bound to defaultTarget when the file declares no targets. A target for
+// defaultTarget may not set its own TLS identity.
+func LoadProbeFile(path, defaultTarget string) (*ProbeFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: could not read probe file (%s): %w", path, err)
+	}
+
+	var file ProbeFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("config: could not parse probe file (%s): %w", path, err)
+	}
+	if len(file.Probes) == 0 {
+		return nil, fmt.Errorf("config: probe file (%s) declares no probes", path)
+	}
+
+	var errs []error
+	defaultTargets := []string{defaultTarget}
+	seenTargets := make(map[string]bool)
+	for i, t := range file.Targets {
+		if t.Address == "" {
+			errs = append(errs, fmt.Errorf("targets[%d]: address must not be empty", i))
+		} else if seenTargets[t.Address] {
+			errs = append(errs, fmt.Errorf("targets[%d]: duplicate address %q", i, t.Address))
+		} else if t.Address == defaultTarget && t.overridesTLS() {
+			// Probes of defaultTarget share the client's own connection.
+			errs = append(errs, fmt.Errorf("targets[%d]: %q is grpc_server_address, which always uses the client TLS settings; remove its tls_* and spiffe_id fields", i, t.Address))
+		}
+		seenTargets[t.Address] = true
+	}
+	if len(file.Targets) > 0 {
+		defaultTargets = defaultTargets[:0]
+		for _, t := range file.Targets {
+			defaultTargets = append(defaultTargets, t.Address)
+		}
+	}
+
+	var probes []ProbeDefinition
+	seen := make(map[[2]string]bool)
+	for i, p := range file.Probes {
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("probes[%d]: name must not be empty", i))
+		}
+		if err := p.normalize(); err != nil {
+			errs = append(errs, fmt.Errorf("probes[%d] (%s): %w", i, p.Name, err))
+		}
+
+		targets := defaultTargets
+		if p.Target != "" {
+			targets = []string{p.Target}
+		}
+		for _, target := range targets {
+			key := [2]string{p.Name, target}
+			if p.Name != "" && seen[key] {
+				errs = append(errs, fmt.Errorf("probes[%d]: duplicate name %q for target %q", i, p.Name, target))
+			}
+			seen[key] = true
+
+			p.Target = target
+			probes = append(probes, p)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("config: invalid probe file (%s):\n%w", path, err)
+	}
+	file.Probes = probes
+	return &file, nil
+}
+
+// Target returns the declared target for address, if any.
+func (f *ProbeFile) Target(address string) (TargetDefinition, bool) {
+	for _, t := range f.Targets {
+		if t.Address == address {
+			return t, true
+		}
+	}
+	return TargetDefinition{}, false
+}
+
+func (p *ProbeDefinition) normalize() error {
+	var errs []error
+
+	if p.Method == "" {
+		p.Method = MethodMonitoring
+	}
+	switch p.Method {
+	case MethodMonitoring:
+	case MethodWatch, MethodHealthCheck, MethodHealthWatch:
+		if p.ExpectedResponse != "" {
+			errs = append(errs, fmt.Errorf("expected_response is not supported for method %s", p.Method))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown method %q (want %s, %s, %s or %s)",
+			p.Method, MethodMonitoring, MethodWatch, MethodHealthCheck, MethodHealthWatch))
+	}
+	if p.Service != "" && p.Method != MethodHealthCheck && p.Method != MethodHealthWatch {
+		errs = append(errs, fmt.Errorf("service is only supported for methods %s and %s", MethodHealthCheck, MethodHealthWatch))
+	}
+
+	if p.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("interval must be positive, got %s", p.Interval))
+	}
+	if p.Deadline < 0 {
+		errs = append(errs, fmt.Errorf("deadline must not be negative, got %s", p.Deadline))
+	} else if p.Deadline == 0 {
+		p.Deadline = DefaultProbeDeadline
+	}
+
+	code, err := ParseCode(p.ExpectedCode)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	p.Code = code
+
+	if p.ExpectedResponse != "" {
+		re, err := regexp.Compile(p.ExpectedResponse)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("expected_response: %w", err))
+		}
+		p.Pattern = re
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParseCode accepts a gRPC code as its Go name ("InvalidArgument"), its
+// canonical name ("INVALID_ARGUMENT") or its number. Empty means OK.
+func ParseCode(s string) (codes.Code, error) {
+	if s == "" {
+		return codes.OK, nil
+	}
+	want := strings.ToLower(strings.ReplaceAll(s, "_", ""))
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.ToLower(c.String()) == want {
+			return c, nil
+		}
+	}
+	// Numbers and canonical names such as "CANCELLED" are handled by codes itself.
+	for _, raw := range []string{s, strconv.Quote(s)} {
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(raw)); err == nil {
+			return c, nil
+		}
+	}
+	return codes.Unknown, fmt.Errorf("unknown gRPC code %q", s)
+}
@@ -0,0 +1,292 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+func writeProbeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write probe file: %v", err)
+	}
+	return path
+}
+
+func TestLoadProbeFile_YAML(t *testing.T) {
+	path := writeProbeFile(t, "probes.yaml", `
+probes:
+  - name: ping
+    message: ping
+    interval: 15s
+    expected_response: "response: pong$"
+  - name: wrong
+    target: eu.example.com:50059
+    message: wrong
+    interval: 2m
+    deadline: 3s
+    expected_code: INVALID_ARGUMENT
+  - name: heartbeat
+    method: Watch
+    interval: 1m
+    expected_code: OK
+`)
+
+	file, err := LoadProbeFile(path, "server:50059")
+	if err != nil {
+		t.Fatalf("LoadProbeFile returned error: %v", err)
+	}
+	defs := file.Probes
+	if len(defs) != 3 {
+		t.Fatalf("expected 3 probes, got %d", len(defs))
+	}
+
+	ping, wrong, heartbeat := defs[0], defs[1], defs[2]
+	if ping.Target != "server:50059" || ping.Method != MethodMonitoring {
+		t.Errorf("ping defaults not applied: target=%q method=%q", ping.Target, ping.Method)
+	}
+	if ping.Deadline != DefaultProbeDeadline {
+		t.Errorf("expected default deadline %s, got %s", DefaultProbeDeadline, ping.Deadline)
+	}
+	if ping.Code != codes.OK || ping.Pattern == nil || !ping.Pattern.MatchString("ping on x, response: pong") {
+		t.Errorf("unexpected ping code/pattern: %v %v", ping.Code, ping.Pattern)
+	}
+	if wrong.Target != "eu.example.com:50059" || wrong.Interval != 2*time.Minute || wrong.Deadline != 3*time.Second {
+		t.Errorf("unexpected wrong probe: %+v", wrong)
+	}
+	if wrong.Code != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", wrong.Code)
+	}
+	if heartbeat.Method != MethodWatch {
+		t.Errorf("expected Watch method, got %q", heartbeat.Method)
+	}
+}
+
+func TestLoadProbeFile_JSON(t *testing.T) {
+	path := writeProbeFile(t, "probes.json", `{
+  "probes": [
+    {"name": "ping", "message": "ping", "interval": "30s", "expected_code": "OK"}
+  ]
+}`)
+
+	file, err := LoadProbeFile(path, "server:50059")
+	if err != nil {
+		t.Fatalf("LoadProbeFile returned error: %v", err)
+	}
+	if len(file.Probes) != 1 || file.Probes[0].Interval != 30*time.Second {
+		t.Fatalf("unexpected probes: %+v", file.Probes)
+	}
+}
+
+func TestLoadProbeFile_Targets(t *testing.T) {
+	path := writeProbeFile(t, "probes.yaml", `
+targets:
+  - address: server:50059
+  - address: eu.example.com:50059
+    tls_cert_file: /certs/eu/client.crt.pem
+    tls_key_file: /certs/eu/client.key.pem
+  - address: us.example.com:50059
+    tls_server_name: server.us.internal
+    spiffe_id: spiffe://example.org/ns/us/sa/server
+probes:
+  - name: ping
+    message: ping
+    interval: 15s
+  - name: wrong
+    target: us.example.com:50059
+    message: wrong
+    interval: 2m
+    expected_code: InvalidArgument
+`)
+
+	file, err := LoadProbeFile(path, "server:50059")
+	if err != nil {
+		t.Fatalf("LoadProbeFile returned error: %v", err)
+	}
+
+	var got []string
+	for _, p := range file.Probes {
+		got = append(got, p.Name+"@"+p.Target)
+	}
+	want := []string{"ping@server:50059", "ping@eu.example.com:50059", "ping@us.example.com:50059", "wrong@us.example.com:50059"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected expanded probes: got %v, want %v", got, want)
+	}
+
+	base := &Config{TLSCertFile: "client.crt.pem", TLSKeyFile: "client.key.pem", TLSCAFile: "ca.crt.pem"}
+
+	eu, ok := file.Target("eu.example.com:50059")
+	if !ok {
+		t.Fatal("expected eu target to be declared")
+	}
+	euCfg := eu.TLSConfig(base)
+	if euCfg.TLSCertFile != "/certs/eu/client.crt.pem" || euCfg.TLSKeyFile != "/certs/eu/client.key.pem" || euCfg.TLSCAFile != "ca.crt.pem" {
+		t.Errorf("unexpected eu TLS config: %+v", euCfg)
+	}
+
+	us, _ := file.Target("us.example.com:50059")
+	usCfg := us.TLSConfig(base)
+	if usCfg.TLSServerName != "server.us.internal" || usCfg.TLSCertFile != "client.crt.pem" {
+		t.Errorf("unexpected us TLS config: %+v", usCfg)
+	}
+	if len(usCfg.SPIFFEAllowedIDs) != 1 || usCfg.SPIFFEAllowedIDs[0] != "spiffe://example.org/ns/us/sa/server" {
+		t.Errorf("unexpected us SPIFFE IDs: %v", usCfg.SPIFFEAllowedIDs)
+	}
+	if base.TLSServerName != "" || base.TLSCertFile != "client.crt.pem" || base.SPIFFEAllowedIDs != nil {
+		t.Errorf("TLSConfig modified the base config: %+v", base)
+	}
+
+	if _, ok := file.Target("unknown:1"); ok {
+		t.Error("expected unknown target not to be found")
+	}
+}
+
+func TestLoadProbeFile_Health(t *testing.T) {
+	path := writeProbeFile(t, "probes.yaml", `
+probes:
+  - name: server-health
+    method: HealthCheck
+    interval: 10s
+  - name: monitoring-health
+    method: HealthWatch
+    service: Monitoring.MonitoringService
+    interval: 30s
+`)
+
+	file, err := LoadProbeFile(path, "server:50059")
+	if err != nil {
+		t.Fatalf("LoadProbeFile returned error: %v", err)
+	}
+	if len(file.Probes) != 2 {
+		t.Fatalf("expected 2 probes, got %d", len(file.Probes))
+	}
+
+	check, watch := file.Probes[0], file.Probes[1]
+	if check.Method != MethodHealthCheck || check.Service != "" {
+		t.Errorf("unexpected check probe: method=%q service=%q", check.Method, check.Service)
+	}
+	if watch.Method != MethodHealthWatch || watch.Service != "Monitoring.MonitoringService" {
+		t.Errorf("unexpected watch probe: method=%q service=%q", watch.Method, watch.Service)
+	}
+	if watch.Code != codes.OK || watch.Deadline != DefaultProbeDeadline {
+		t.Errorf("defaults not applied: code=%s deadline=%s", watch.Code, watch.Deadline)
+	}
+}
+
+func TestLoadProbeFile_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr []string
+	}{
+		{
+			name:    "no probes",
+			content: "probes: []",
+			wantErr: []string{"declares no probes"},
+		},
+		{
+			name: "aggregated errors",
+			content: `
+probes:
+  - name: a
+    method: Nope
+    interval: 0s
+    expected_code: Sometimes
+  - name: a
+    interval: 1s
+    expected_response: "("
+`,
+			wantErr: []string{`unknown method "Nope"`, "interval must be positive", `unknown gRPC code "Sometimes"`, `duplicate name "a" for target "server:50059"`, "expected_response"},
+		},
+		{
+			name: "bad targets",
+			content: `
+targets:
+  - address: ""
+  - address: a:1
+  - address: a:1
+probes:
+  - name: ping
+    interval: 1s
+`,
+			wantErr: []string{"targets[0]: address must not be empty", `targets[2]: duplicate address "a:1"`},
+		},
+		{
+			name: "TLS settings for the server address",
+			content: `
+targets:
+  - address: server:50059
+    spiffe_id: spiffe://example.org/ns/other/sa/server
+probes:
+  - name: ping
+    interval: 1s
+`,
+			wantErr: []string{`targets[0]: "server:50059" is grpc_server_address`},
+		},
+		{
+			name: "misplaced service",
+			content: `
+probes:
+  - name: health
+    method: HealthCheck
+    interval: 1s
+    expected_response: SERVING
+  - name: ping
+    service: Monitoring.MonitoringService
+    interval: 1s
+`,
+			wantErr: []string{"expected_response is not supported for method HealthCheck", "service is only supported"},
+		},
+		{
+			name:    "bad duration",
+			content: "probes:\n  - name: a\n    interval: soon\n",
+			wantErr: []string{"could not parse probe file"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeProbeFile(t, "probes.yaml", tc.content)
+			_, err := LoadProbeFile(path, "server:50059")
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			for _, want := range tc.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+
+	if _, err := LoadProbeFile("/nonexistent/probes.yaml", ""); err == nil {
+		t.Error("expected error for missing file, got nil")
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	tests := map[string]codes.Code{
+		"":                 codes.OK,
+		"OK":               codes.OK,
+		"InvalidArgument":  codes.InvalidArgument,
+		"INVALID_ARGUMENT": codes.InvalidArgument,
+		"CANCELLED":        codes.Canceled,
+		"14":               codes.Unavailable,
+	}
+	for in, want := range tests {
+		got, err := ParseCode(in)
+		if err != nil || got != want {
+			t.Errorf("ParseCode(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseCode("99"); err == nil {
+		t.Error("expected error for out-of-range code")
+	}
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validate checks ports, files and durations and reports every problem at
+// once. TLS policy settings are checked by security.ParsePolicy.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	check("grpc_server_address", validateAddress(c.GRPCServerAddress))
+	check("metrics_port", validatePort(c.MetricsPort))
+	check("transport_mode", validateChoice(c.TransportMode, TransportPlaintext, TransportTLS, TransportMTLS))
+
+	// Certificate files are only read in TLS modes, and SPIFFE replaces them.
+	if !c.SPIFFEEnabled() {
+		switch c.TransportMode {
+		case TransportMTLS:
+			check("tls_cert_file", validateFile(c.TLSCertFile))
+			check("tls_key_file", validateFile(c.TLSKeyFile))
+			check("tls_ca_file", validateFile(c.TLSCAFile))
+		case TransportTLS:
+			check("tls_ca_file", validateFile(c.TLSCAFile))
+		}
+	}
+	if c.TLSCRLFile != "" {
+		check("tls_crl_file", validateFile(c.TLSCRLFile))
+	}
+	if c.ProbesFile != "" {
+		check("probes_file", validateFile(c.ProbesFile))
+	}
+	if c.SPIFFEEndpointSocket != "" && c.SPIFFESVIDDir != "" {
+		errs = append(errs, errors.New("spiffe_endpoint_socket and spiffe_svid_dir are mutually exclusive"))
+	}
+	if c.SPIFFESVIDDir != "" {
+		check("spiffe_svid_dir", validateDir(c.SPIFFESVIDDir))
+	}
+
+	if c.ProbeFailureThreshold < 1 {
+		errs = append(errs, fmt.Errorf("probe_failure_threshold: %d must be at least 1", c.ProbeFailureThreshold))
+	}
+	if c.TracesExporter != "" {
+		check("traces_exporter", validateChoice(c.TracesExporter, TracesExporterNone, TracesExporterStdout, TracesExporterOTLP))
+	}
+	if c.TracesExporter == TracesExporterOTLP && c.OTLPCollectorEndpoint == "" {
+		errs = append(errs, errors.New("traces_exporter: otlp needs otlp_collector_endpoint"))
+	}
+	if c.TracesFile != "" {
+		if c.TracesExporterName() != TracesExporterStdout {
+			errs = append(errs, errors.New("traces_file: only used by the stdout exporter"))
+		}
+		check("traces_file", validateDir(filepath.Dir(c.TracesFile)))
+	}
+	for _, name := range c.MetricsExporter {
+		check("metrics_exporter", validateChoice(name, MetricsExporterPrometheus, MetricsExporterOTLP, MetricsExporterNone))
+	}
+	if slices.Contains(c.MetricsExporter, MetricsExporterNone) && len(c.MetricsExporter) > 1 {
+		errs = append(errs, errors.New("metrics_exporter: none cannot be combined with other exporters"))
+	}
+	if slices.Contains(c.MetricsExporter, MetricsExporterOTLP) {
+		if c.OTLPCollectorEndpoint == "" {
+			errs = append(errs, errors.New("metrics_exporter: otlp needs otlp_collector_endpoint"))
+		}
+		check("metrics_export_interval", validateDuration(c.MetricsExportInterval, true))
+	}
+	if c.OTLPProtocol != "" {
+		check("otlp_protocol", validateChoice(c.OTLPProtocol, OTLPProtocolGRPC, OTLPProtocolHTTP))
+	}
+	if c.OTLPCompression != "" {
+		check("otlp_compression", validateChoice(c.OTLPCompression, OTLPCompressionNone, OTLPCompressionGzip))
+	}
+	if c.OTLPCAFile != "" {
+		check("otlp_ca_file", validateFile(c.OTLPCAFile))
+	}
+	if c.OTLPCertFile != "" || c.OTLPKeyFile != "" {
+		check("otlp_cert_file", validateFile(c.OTLPCertFile))
+		check("otlp_key_file", validateFile(c.OTLPKeyFile))
+	}
+	if strings.HasPrefix(c.OTLPCollectorEndpoint, "http://") && c.OTLPTLSEnabled() {
+		errs = append(errs, errors.New("otlp_collector_endpoint: an http:// URL cannot be used with otlp_ca_file or otlp_cert_file"))
+	}
+	for i, pair := range c.OTLPHeaders {
+		check("otlp_headers", validateHeader(i, pair))
+	}
+	check("otlp_timeout", validateDuration(c.OTLPTimeout, false))
+	if c.LogLevel != "" {
+		check("log_level", validateChoice(c.LogLevel, LogLevelInfo, LogLevelDebug))
+	}
+	if c.TracesSampler != "" {
+		check("traces_sampler", validateChoice(c.TracesSampler,
+			SamplerAlwaysOn, SamplerAlwaysOff, SamplerTraceIDRatio,
+			SamplerParentBasedAlwaysOn, SamplerParentBasedAlwaysOff, SamplerParentBasedTraceIDRatio))
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("trace_sample_ratio: %g must be between 0 and 1", c.TraceSampleRatio))
+	}
+	methods := make(map[string]bool)
+	for _, entry := range c.TracesSamplerOverrides {
+		o, err := ParseSamplerOverride(entry)
+		check("traces_sampler_overrides", err)
+		if err == nil && methods[o.Method] {
+			errs = append(errs, fmt.Errorf("traces_sampler_overrides: %s is listed more than once", o.Method))
+		}
+		methods[o.Method] = true
+	}
+	check("tls_reload_interval", validateDuration(c.TLSReloadInterval, false))
+	check("watch_interval", validateDuration(c.WatchInterval, true))
+	check("ping_stream_interval", validateDuration(c.PingStreamInterval, true))
+
+	return errors.Join(errs...)
+}
+
+// validateAddress checks a host:port address; the host may be empty.
+func validateAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q is not a host:port address", addr)
+	}
+	return validatePort(port)
+}
+
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q is not a port number between 1 and 65535", port)
+	}
+	return nil
+}
+
+func validateChoice(v string, choices ...string) error {
+	if !slices.Contains(choices, v) {
+		return fmt.Errorf("%q must be one of %s", v, strings.Join(choices, ", "))
+	}
+	return nil
+}
+
+func validateFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
+
+func validateDir(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
+
+// validateDuration rejects negative durations and, if positive is set, zero.
+func validateDuration(d time.Duration, positive bool) error {
+	if d < 0 || (positive && d == 0) {
+		want := "zero or more"
+		if positive {
+			want = "more than zero"
+		}
+		return fmt.Errorf("%s must be %s", d, want)
+	}
+	return nil
+}
+
+// validateHeader checks the name=value pair at index i without echoing
+// it, as it usually holds a secret.
+func validateHeader(i int, pair string) error {
+	name, value, ok := strings.Cut(pair, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("entry %d is not a name=value pair", i+1)
+	}
+	if _, err := url.PathUnescape(strings.TrimSpace(value)); err != nil {
+		return fmt.Errorf("the value of %s is not valid percent-encoding", name)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+// Package logging adds a debug level to the standard log package. Lines
+// written with log.Printf are always shown; lines written with Debugf only
+// while debug logging is on, which can change at runtime.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+var debug atomic.Bool
+
+// SetDebug turns debug lines on or off.
+func SetDebug(on bool) {
+	debug.Store(on)
+}
+
+// Debugf logs like log.Printf if debug lines are on.
+func Debugf(format string, args ...any) {
+	if debug.Load() {
+		_ = log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestDebugf(t *testing.T) {
+	var out bytes.Buffer
+	w, flags := log.Writer(), log.Flags()
+	log.SetOutput(&out)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(w)
+		log.SetFlags(flags)
+		SetDebug(false)
+	})
+
+	Debugf("[TEST] hidden %d", 1)
+	SetDebug(true)
+	Debugf("[TEST] shown %d", 2)
+	SetDebug(false)
+	Debugf("[TEST] hidden %d", 3)
+
+	if got, want := out.String(), "[TEST] shown 2\n"; got != want {
+		t.Errorf("unexpected output: got %q, want %q", got, want)
+	}
+}
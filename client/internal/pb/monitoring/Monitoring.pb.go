@@ -9,6 +9,7 @@ package monitoring
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
 	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
@@ -162,22 +163,193 @@ func (x *MonitoringServerResponse) GetMessage() string {
 	return ""
 }
 
+type WatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// How often the server pushes a heartbeat; the server default is used when unset.
+	Interval      *durationpb.Duration `protobuf:"bytes,1,opt,name=interval,proto3" json:"interval,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_Monitoring_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_Monitoring_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_Monitoring_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WatchRequest) GetInterval() *durationpb.Duration {
+	if x != nil {
+		return x.Interval
+	}
+	return nil
+}
+
+type Heartbeat struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sequence      uint64                 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	SentAt        *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Heartbeat) Reset() {
+	*x = Heartbeat{}
+	mi := &file_Monitoring_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Heartbeat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Heartbeat) ProtoMessage() {}
+
+func (x *Heartbeat) ProtoReflect() protoreflect.Message {
+	mi := &file_Monitoring_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Heartbeat.ProtoReflect.Descriptor instead.
+func (*Heartbeat) Descriptor() ([]byte, []int) {
+	return file_Monitoring_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Heartbeat) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *Heartbeat) GetSentAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.SentAt
+	}
+	return nil
+}
+
+type PingStreamResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The client message being echoed, including its original request_date.
+	ClientRequest *Client                `protobuf:"bytes,1,opt,name=client_request,json=clientRequest,proto3" json:"client_request,omitempty"`
+	ReceivedAt    *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=received_at,json=receivedAt,proto3" json:"received_at,omitempty"`
+	SentAt        *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PingStreamResponse) Reset() {
+	*x = PingStreamResponse{}
+	mi := &file_Monitoring_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingStreamResponse) ProtoMessage() {}
+
+func (x *PingStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_Monitoring_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingStreamResponse.ProtoReflect.Descriptor instead.
+func (*PingStreamResponse) Descriptor() ([]byte, []int) {
+	return file_Monitoring_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PingStreamResponse) GetClientRequest() *Client {
+	if x != nil {
+		return x.ClientRequest
+	}
+	return nil
+}
+
+func (x *PingStreamResponse) GetReceivedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReceivedAt
+	}
+	return nil
+}
+
+func (x *PingStreamResponse) GetSentAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.SentAt
+	}
+	return nil
+}
+
 var File_Monitoring_proto protoreflect.FileDescriptor
 
 const file_Monitoring_proto_rawDesc = "" +
 	"\n" +
 	"\x10Monitoring.proto\x12\n" +
-	"Monitoring\x1a\x1fgoogle/protobuf/timestamp.proto\"a\n" +
+	"Monitoring\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"a\n" +
 	"\x06Client\x12\x18\n" +
 	"\amessage\x18\x01 \x01(\tR\amessage\x12=\n" +
 	"\frequest_date\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\vrequestDate\"T\n" +
 	"\x17MonitoringClientRequest\x129\n" +
 	"\x0eclient_request\x18\x01 \x01(\v2\x12.Monitoring.ClientR\rclientRequest\"4\n" +
 	"\x18MonitoringServerResponse\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\tR\amessage2l\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\"E\n" +
+	"\fWatchRequest\x125\n" +
+	"\binterval\x18\x01 \x01(\v2\x19.google.protobuf.DurationR\binterval\"\\\n" +
+	"\tHeartbeat\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x123\n" +
+	"\asent_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x06sentAt\"\xc1\x01\n" +
+	"\x12PingStreamResponse\x129\n" +
+	"\x0eclient_request\x18\x01 \x01(\v2\x12.Monitoring.ClientR\rclientRequest\x12;\n" +
+	"\vreceived_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"receivedAt\x123\n" +
+	"\asent_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x06sentAt2\xee\x01\n" +
 	"\x11MonitoringService\x12W\n" +
 	"\n" +
-	"Monitoring\x12#.Monitoring.MonitoringClientRequest\x1a$.Monitoring.MonitoringServerResponseB,Z*server/internal/pb/monitoring;monitoringpbb\x06proto3"
+	"Monitoring\x12#.Monitoring.MonitoringClientRequest\x1a$.Monitoring.MonitoringServerResponse\x12:\n" +
+	"\x05Watch\x12\x18.Monitoring.WatchRequest\x1a\x15.Monitoring.Heartbeat0\x01\x12D\n" +
+	"\n" +
+	"PingStream\x12\x12.Monitoring.Client\x1a\x1e.Monitoring.PingStreamResponse(\x010\x01B,Z*server/internal/pb/monitoring;monitoringpbb\x06proto3"
 
 var (
 	file_Monitoring_proto_rawDescOnce sync.Once
@@ -191,23 +363,36 @@ func file_Monitoring_proto_rawDescGZIP() []byte {
 	return file_Monitoring_proto_rawDescData
 }
 
-var file_Monitoring_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_Monitoring_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_Monitoring_proto_goTypes = []any{
 	(*Client)(nil),                   // 0: Monitoring.Client
 	(*MonitoringClientRequest)(nil),  // 1: Monitoring.MonitoringClientRequest
 	(*MonitoringServerResponse)(nil), // 2: Monitoring.MonitoringServerResponse
-	(*timestamppb.Timestamp)(nil),    // 3: google.protobuf.Timestamp
+	(*WatchRequest)(nil),             // 3: Monitoring.WatchRequest
+	(*Heartbeat)(nil),                // 4: Monitoring.Heartbeat
+	(*PingStreamResponse)(nil),       // 5: Monitoring.PingStreamResponse
+	(*timestamppb.Timestamp)(nil),    // 6: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),      // 7: google.protobuf.Duration
 }
 var file_Monitoring_proto_depIdxs = []int32{
-	3, // 0: Monitoring.Client.request_date:type_name -> google.protobuf.Timestamp
-	0, // 1: Monitoring.MonitoringClientRequest.client_request:type_name -> Monitoring.Client
-	1, // 2: Monitoring.MonitoringService.Monitoring:input_type -> Monitoring.MonitoringClientRequest
-	2, // 3: Monitoring.MonitoringService.Monitoring:output_type -> Monitoring.MonitoringServerResponse
-	3, // [3:4] is the sub-list for method output_type
-	2, // [2:3] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	6,  // 0: Monitoring.Client.request_date:type_name -> google.protobuf.Timestamp
+	0,  // 1: Monitoring.MonitoringClientRequest.client_request:type_name -> Monitoring.Client
+	7,  // 2: Monitoring.WatchRequest.interval:type_name -> google.protobuf.Duration
+	6,  // 3: Monitoring.Heartbeat.sent_at:type_name -> google.protobuf.Timestamp
+	0,  // 4: Monitoring.PingStreamResponse.client_request:type_name -> Monitoring.Client
+	6,  // 5: Monitoring.PingStreamResponse.received_at:type_name -> google.protobuf.Timestamp
+	6,  // 6: Monitoring.PingStreamResponse.sent_at:type_name -> google.protobuf.Timestamp
+	1,  // 7: Monitoring.MonitoringService.Monitoring:input_type -> Monitoring.MonitoringClientRequest
+	3,  // 8: Monitoring.MonitoringService.Watch:input_type -> Monitoring.WatchRequest
+	0,  // 9: Monitoring.MonitoringService.PingStream:input_type -> Monitoring.Client
+	2,  // 10: Monitoring.MonitoringService.Monitoring:output_type -> Monitoring.MonitoringServerResponse
+	4,  // 11: Monitoring.MonitoringService.Watch:output_type -> Monitoring.Heartbeat
+	5,  // 12: Monitoring.MonitoringService.PingStream:output_type -> Monitoring.PingStreamResponse
+	10, // [10:13] is the sub-list for method output_type
+	7,  // [7:10] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
 }
 
 func init() { file_Monitoring_proto_init() }
@@ -221,7 +406,7 @@ func file_Monitoring_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_Monitoring_proto_rawDesc), len(file_Monitoring_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
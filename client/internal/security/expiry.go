@@ -0,0 +1,47 @@
+package security
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var certExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "tls_certificate_expiry_timestamp_seconds",
+	Help: "NotAfter of the certificates loaded from disk as a Unix timestamp, by file, role (leaf or ca), subject and SANs",
+}, []string{"file", "role", "subject", "sans"})
+
+// certLabels returns the label values identifying cert.
+func certLabels(cert *x509.Certificate) (subject, sans string) {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	return cert.Subject.String(), strings.Join(names, ",")
+}
+
+// parseCertificates returns every certificate in a PEM bundle, skipping
+// blocks that do not parse.
+func parseCertificates(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
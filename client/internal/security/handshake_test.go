@@ -0,0 +1,214 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+// clientHandshake dials authority through creds against a server using
+// serverConfig and returns the client side error. It uses TCP rather than
+// net.Pipe, whose unbuffered writes deadlock when the client sends an
+// alert while the server is still writing its flight.
+func clientHandshake(t *testing.T, ctx context.Context, creds credentials.TransportCredentials, authority string, serverConfig *tls.Config) error {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = tls.Server(conn, serverConfig).Handshake()
+	}()
+
+	rawConn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer rawConn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, _, err = creds.ClientHandshake(ctx, authority, rawConn)
+	return err
+}
+
+// handshakeCount returns the number of handshakes timed with result.
+func handshakeCount(t *testing.T, result string) uint64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := handshakeDuration.WithLabelValues(result).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatalf("failed to read handshake histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// handshakeSpans records the spans of this package. The package tracer
+// keeps delegating to the first provider passed to otel.SetTracerProvider,
+// so it is installed once for every run of the tests.
+var handshakeSpans = sync.OnceValue(func() *tracetest.SpanRecorder {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	return spans
+})
+
+func TestClientCredentials_HandshakeMetrics(t *testing.T) {
+	spans := handshakeSpans()
+
+	ca, otherCA := newTestCA(t, "Test CA"), newTestCA(t, "Other CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "client")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+
+	revoked := ca.keyPair(t, "revoked-server")
+	crlFile := filepath.Join(t.TempDir(), "crl.pem")
+	writeCRL(t, crlFile, ca.crlPEM(t, time.Now().Add(time.Hour), map[*big.Int]int{leafOf(t, revoked).SerialNumber: 1}))
+	crls, err := NewCRLSet(crlFile)
+	if err != nil {
+		t.Fatalf("NewCRLSet returned error: %v", err)
+	}
+	creds := ClientCredentials(r, "", WithCRLs(crls))
+
+	expiredPEM, expiredKeyPEM := ca.issueUntil(t, "expired-server", time.Now().Add(-time.Hour))
+	expired, err := tls.X509KeyPair(expiredPEM, expiredKeyPEM)
+	if err != nil {
+		t.Fatalf("failed to build key pair: %v", err)
+	}
+
+	serverConfig := func(cert tls.Certificate) *tls.Config {
+		return &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}}
+	}
+	oldTLS := serverConfig(ca.keyPair(t, "old-server"))
+	oldTLS.MinVersion, oldTLS.MaxVersion = tls.VersionTLS10, tls.VersionTLS11
+
+	tests := []struct {
+		name       string
+		authority  string
+		server     *tls.Config
+		wantReason string
+	}{
+		{name: "success", authority: "localhost", server: serverConfig(ca.keyPair(t, "server"))},
+		{name: "bad SAN", authority: "example.com", server: serverConfig(ca.keyPair(t, "server")), wantReason: reasonBadSAN},
+		{name: "expired", authority: "localhost", server: serverConfig(expired), wantReason: reasonExpired},
+		{name: "unknown CA", authority: "localhost", server: serverConfig(otherCA.keyPair(t, "server")), wantReason: reasonUnknownCA},
+		{name: "protocol version", authority: "localhost", server: oldTLS, wantReason: reasonProtocolVersion},
+		{name: "revoked", authority: "localhost", server: serverConfig(revoked), wantReason: reasonRevoked},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := "success"
+			if tc.wantReason != "" {
+				result = "failure"
+			}
+			timed := handshakeCount(t, result)
+			failures := testutil.ToFloat64(handshakeFailures.WithLabelValues(tc.wantReason))
+			spans.Reset()
+
+			ctx, parent := otel.Tracer("test").Start(context.Background(), "probe")
+			err := clientHandshake(t, ctx, creds, tc.authority, tc.server)
+			parent.End()
+			if (err != nil) != (tc.wantReason != "") {
+				t.Fatalf("ClientHandshake: got %v, want failure=%v", err, tc.wantReason != "")
+			}
+
+			if got := handshakeCount(t, result) - timed; got != 1 {
+				t.Errorf("expected 1 timed %s handshake, got %d", result, got)
+			}
+			if tc.wantReason != "" {
+				if got := testutil.ToFloat64(handshakeFailures.WithLabelValues(tc.wantReason)) - failures; got != 1 {
+					t.Errorf("expected 1 failure with reason %s, got %v", tc.wantReason, got)
+				}
+			}
+
+			ended := spans.Ended()
+			if len(ended) != 2 || len(ended[0].Events()) != 1 {
+				t.Fatalf("expected a handshake span with one event under the probe span, got %d spans", len(ended))
+			}
+			if got, want := ended[0].Parent().SpanID(), parent.SpanContext().SpanID(); got != want {
+				t.Errorf("handshake span parent: got %s, want %s", got, want)
+			}
+			if kind := ended[0].SpanKind(); kind != trace.SpanKindInternal {
+				t.Errorf("span kind: got %v, want %v", kind, trace.SpanKindInternal)
+			}
+			event := ended[0].Events()[0]
+			wantEvent := "tls.handshake.completed"
+			if tc.wantReason != "" {
+				wantEvent = "tls.handshake.failed"
+			}
+			if event.Name != wantEvent {
+				t.Errorf("span event: got %q, want %q", event.Name, wantEvent)
+			}
+			for _, attr := range event.Attributes {
+				if attr.Key == "tls.failure.reason" && attr.Value.AsString() != tc.wantReason {
+					t.Errorf("span reason: got %q, want %q", attr.Value.AsString(), tc.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestClassifyHandshakeError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "expired",
+			err:  &tls.CertificateVerificationError{Err: x509.CertificateInvalidError{Reason: x509.Expired}},
+			want: reasonExpired,
+		},
+		{
+			name: "other invalid certificate",
+			err:  x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign},
+			want: reasonOther,
+		},
+		{
+			name: "timeout",
+			err:  &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded},
+			want: reasonTimeout,
+		},
+		{name: "peer alert", err: &net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}, want: reasonRejectedByPeer},
+		{name: "peer certificate required", err: &net.OpError{Op: "remote error", Err: errors.New("tls: certificate required")}, want: reasonNoClientCert},
+		{name: "revoked", err: fmt.Errorf("security: certificate is %w", errRevoked), want: reasonRevoked},
+		{name: "unknown", err: errors.New("EOF"), want: reasonOther},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := classifyHandshakeError(tc.err); got != tc.want {
+				t.Errorf("classifyHandshakeError(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
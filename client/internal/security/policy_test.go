@@ -0,0 +1,143 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"slices"
+	"strings"
+	"testing"
+
+	"client/internal/config"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want Policy
+	}{
+		{
+			name: "default",
+			cfg:  config.Config{TLSPolicy: config.TLSPolicyDefault},
+			want: Policy{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS13},
+		},
+		{
+			name: "strict",
+			cfg:  config.Config{TLSPolicy: config.TLSPolicyStrict},
+			want: Policy{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13},
+		},
+		{
+			name: "TLS 1.2 only with suites and curves",
+			cfg: config.Config{
+				TLSMaxVersion:   "1.2",
+				TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+				TLSCurves:       []string{"p-384", "X25519"},
+				TLSALPN:         []string{"h2", "grpc-exp"},
+			},
+			want: Policy{
+				MinVersion:       tls.VersionTLS12,
+				MaxVersion:       tls.VersionTLS12,
+				CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+				CurvePreferences: []tls.CurveID{tls.CurveP384, tls.X25519},
+				NextProtos:       []string{"h2", "grpc-exp"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParsePolicy(&tc.cfg)
+			if err != nil {
+				t.Fatalf("ParsePolicy returned error: %v", err)
+			}
+			if got.MinVersion != tc.want.MinVersion || got.MaxVersion != tc.want.MaxVersion ||
+				!slices.Equal(got.CipherSuites, tc.want.CipherSuites) ||
+				!slices.Equal(got.CurvePreferences, tc.want.CurvePreferences) ||
+				!slices.Equal(got.NextProtos, tc.want.NextProtos) {
+				t.Errorf("ParsePolicy: got %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr []string
+	}{
+		{name: "unknown preset", cfg: config.Config{TLSPolicy: "strcit"}, wantErr: []string{`TLS_POLICY="strcit"`}},
+		{name: "unknown version", cfg: config.Config{TLSMinVersion: "1.1"}, wantErr: []string{`TLS_MIN_VERSION="1.1"`}},
+		{
+			name:    "strict with TLS 1.2",
+			cfg:     config.Config{TLSPolicy: config.TLSPolicyStrict, TLSMinVersion: "1.2"},
+			wantErr: []string{"allows TLS 1.3 only"},
+		},
+		{
+			name:    "min above max",
+			cfg:     config.Config{TLSMinVersion: "1.3", TLSMaxVersion: "1.2"},
+			wantErr: []string{"above the maximum"},
+		},
+		{
+			name:    "suites with TLS 1.3 only",
+			cfg:     config.Config{TLSPolicy: config.TLSPolicyStrict, TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}},
+			wantErr: []string{"only applies to TLS 1.2"},
+		},
+		{
+			name: "bad suites",
+			cfg: config.Config{TLSCipherSuites: []string{
+				"TLS_RSA_WITH_RC4_128_SHA", "TLS_AES_128_GCM_SHA256", "TLS_FOO",
+			}},
+			wantErr: []string{"TLS_RSA_WITH_RC4_128_SHA is insecure", "TLS_AES_128_GCM_SHA256 is a TLS 1.3 suite", `unknown cipher suite "TLS_FOO"`},
+		},
+		{name: "unknown curve", cfg: config.Config{TLSCurves: []string{"P-224"}}, wantErr: []string{`unknown curve "P-224"`}},
+		{name: "ALPN without h2", cfg: config.Config{TLSALPN: []string{"http/1.1"}}, wantErr: []string{"must include h2"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParsePolicy(&tc.cfg)
+			if err == nil {
+				t.Fatal("expected ParsePolicy to fail")
+			}
+			for _, want := range tc.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTransportCredentials_StrictPolicy(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "client")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	creds, err := TransportCredentials(ctx, &config.Config{
+		TransportMode: config.TransportTLS,
+		TLSCAFile:     files.ca,
+		TLSServerName: "localhost",
+		TLSPolicy:     config.TLSPolicyStrict,
+	})
+	if err != nil {
+		t.Fatalf("TransportCredentials returned error: %v", err)
+	}
+
+	server := func(maxVersion uint16) credentials.TransportCredentials {
+		return credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{ca.keyPair(t, "server")},
+			MaxVersion:   maxVersion,
+		})
+	}
+	if err := checkHealth(startTransportServer(t, server(tls.VersionTLS13)), creds); err != nil {
+		t.Errorf("expected a TLS 1.3 server to be accepted: %v", err)
+	}
+	if err := checkHealth(startTransportServer(t, server(tls.VersionTLS12)), creds); err == nil {
+		t.Error("expected a TLS 1.2 server to be rejected")
+	}
+}
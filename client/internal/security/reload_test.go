@@ -0,0 +1,394 @@
+package security
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testCA issues short-lived ECDSA certificates for reload tests.
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	return &testCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a leaf for localhost usable for both server and client auth.
+func (ca *testCA) issue(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	return ca.issueUntil(t, cn, time.Now().Add(12*time.Hour))
+}
+
+// issueUntil is issue with an explicit NotAfter.
+func (ca *testCA) issueUntil(t *testing.T, cn string, notAfter time.Time) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial number: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) keyPair(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issue(t, cn)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to build key pair: %v", err)
+	}
+	return pair
+}
+
+type tlsFiles struct {
+	cert, key, ca string
+}
+
+func writeTLSFiles(t *testing.T, files tlsFiles, certPEM, keyPEM, caPEM []byte) {
+	t.Helper()
+
+	for path, data := range map[string][]byte{files.cert: certPEM, files.key: keyPEM, files.ca: caPEM} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+}
+
+func newTestFiles(t *testing.T) tlsFiles {
+	t.Helper()
+
+	dir := t.TempDir()
+	return tlsFiles{
+		cert: filepath.Join(dir, "client.crt.pem"),
+		key:  filepath.Join(dir, "client.key.pem"),
+		ca:   filepath.Join(dir, "ca.crt.pem"),
+	}
+}
+
+func leafSerial(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse leaf: %v", err)
+	}
+	return leaf.SerialNumber.String()
+}
+
+func TestReloader_Reload(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "client-1")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+	first := leafSerial(t, r.Certificate())
+
+	successes := testutil.ToFloat64(reloadsTotal.WithLabelValues("success"))
+	failures := testutil.ToFloat64(reloadsTotal.WithLabelValues("failure"))
+
+	certPEM, keyPEM = ca.issue(t, "client-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	second := leafSerial(t, r.Certificate())
+	if second == first {
+		t.Error("expected a new certificate after reload")
+	}
+
+	// A key that does not match the certificate must not replace the pair.
+	_, otherKey := ca.issue(t, "client-3")
+	if err := os.WriteFile(files.key, otherKey, 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Error("expected Reload to fail for a mismatched key")
+	}
+	if got := leafSerial(t, r.Certificate()); got != second {
+		t.Error("expected the previous certificate to stay in use after a failed reload")
+	}
+
+	if got := testutil.ToFloat64(reloadsTotal.WithLabelValues("success")) - successes; got != 1 {
+		t.Errorf("expected 1 successful reload, got %v", got)
+	}
+	if got := testutil.ToFloat64(reloadsTotal.WithLabelValues("failure")) - failures; got != 1 {
+		t.Errorf("expected 1 failed reload, got %v", got)
+	}
+}
+
+func TestReloader_ExpiryMetrics(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	notAfter := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	certPEM, keyPEM := ca.issueUntil(t, "client-1", notAfter)
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+
+	leaf := prometheus.Labels{"file": files.cert, "role": "leaf", "subject": "CN=client-1", "sans": "localhost,127.0.0.1"}
+	if got := testutil.ToFloat64(certExpiry.With(leaf)); got != float64(notAfter.Unix()) {
+		t.Errorf("leaf expiry: got %v, want %v", got, notAfter.Unix())
+	}
+	caLabels := prometheus.Labels{"file": files.ca, "role": "ca", "subject": "CN=Test CA", "sans": ""}
+	if got := testutil.ToFloat64(certExpiry.With(caLabels)); got != float64(ca.cert.NotAfter.Unix()) {
+		t.Errorf("CA expiry: got %v, want %v", got, ca.cert.NotAfter.Unix())
+	}
+
+	// After a rotation only the new leaf is reported.
+	certPEM, keyPEM = ca.issue(t, "client-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if certExpiry.Delete(leaf) {
+		t.Error("expected the rotated-out leaf to be removed from the expiry metric")
+	}
+	leaf["subject"] = "CN=client-2"
+	if !certExpiry.Delete(leaf) {
+		t.Error("expected the new leaf in the expiry metric")
+	}
+}
+
+func TestReloader_Run(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "client-1")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+	first := leafSerial(t, r.Certificate())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx, 10*time.Millisecond)
+
+	// Make sure the new files do not share the old modification time.
+	certPEM, keyPEM = ca.issue(t, "client-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	later := time.Now().Add(time.Second)
+	for _, path := range []string{files.cert, files.key} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatalf("failed to touch %s: %v", path, err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for leafSerial(t, r.Certificate()) == first {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded by Run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloadFiles(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "client-1")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+	first := leafSerial(t, r.Certificate())
+
+	// Polling is disabled, so only ReloadFiles picks up the new files.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx, 0)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sourcesMu.Lock()
+		_, running := sources[r]
+		sourcesMu.Unlock()
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Run did not register the reloader")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	certPEM, keyPEM = ca.issue(t, "client-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	// Sources left running by other tests may fail to reload; only the
+	// errors about these files count.
+	if err := ReloadFiles(); err != nil && strings.Contains(err.Error(), files.cert) {
+		t.Fatalf("ReloadFiles returned error: %v", err)
+	}
+	if leafSerial(t, r.Certificate()) == first {
+		t.Error("expected ReloadFiles to load the new certificate")
+	}
+
+	if err := os.WriteFile(files.key, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("failed to corrupt key: %v", err)
+	}
+	if err := ReloadFiles(); err == nil || !strings.Contains(err.Error(), files.key) {
+		t.Errorf("expected ReloadFiles to report the broken key pair, got: %v", err)
+	}
+}
+
+func TestClientCredentials_Rotation(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "Old CA"), newTestCA(t, "New CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := oldCA.issue(t, "client-1")
+	writeTLSFiles(t, files, certPEM, keyPEM, oldCA.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+	creds := ClientCredentials(r, "")
+
+	// handshake dials authority through creds against a server presenting
+	// serverCert and trusting clientRoots. It returns the serial of the
+	// client certificate the server saw and the client-side error.
+	handshake := func(authority string, serverCert tls.Certificate, clientRoots *x509.CertPool) (string, error) {
+		cliConn, srvConn := net.Pipe()
+		defer cliConn.Close()
+		defer srvConn.Close()
+
+		serials := make(chan string, 1)
+		go func() {
+			defer srvConn.Close()
+			server := tls.Server(srvConn, &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    clientRoots,
+				NextProtos:   []string{"h2"},
+			})
+			if err := server.Handshake(); err != nil {
+				serials <- ""
+				return
+			}
+			serials <- server.ConnectionState().PeerCertificates[0].SerialNumber.String()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, _, err := creds.ClientHandshake(ctx, authority, cliConn)
+		if err != nil {
+			cliConn.Close()
+		}
+		return <-serials, err
+	}
+
+	oldRoots, newRoots := x509.NewCertPool(), x509.NewCertPool()
+	oldRoots.AddCert(oldCA.cert)
+	newRoots.AddCert(newCA.cert)
+
+	serial, err := handshake("localhost:50059", oldCA.keyPair(t, "server"), oldRoots)
+	if err != nil {
+		t.Fatalf("handshake with the initial CA failed: %v", err)
+	}
+	if want := leafSerial(t, r.Certificate()); serial != want {
+		t.Errorf("server saw client certificate %s, want %s", serial, want)
+	}
+	if _, err := handshake("localhost:50059", newCA.keyPair(t, "server"), newRoots); err == nil {
+		t.Fatal("expected a server cert from an untrusted CA to be rejected")
+	}
+	if _, err := handshake("127.0.0.2:50059", oldCA.keyPair(t, "server"), oldRoots); err == nil {
+		t.Fatal("expected a server cert without the dialled IP to be rejected")
+	}
+
+	// Rotate to the new CA and a client certificate issued by it.
+	certPEM, keyPEM = newCA.issue(t, "client-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, newCA.certPEM)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+
+	serial, err = handshake("localhost:50059", newCA.keyPair(t, "server"), newRoots)
+	if err != nil {
+		t.Fatalf("handshake after rotation failed: %v", err)
+	}
+	if want := leafSerial(t, r.Certificate()); serial != want {
+		t.Errorf("server saw client certificate %s, want rotated %s", serial, want)
+	}
+	if _, err := handshake("localhost:50059", oldCA.keyPair(t, "server"), oldRoots); err == nil {
+		t.Error("expected a server cert from the retired CA to be rejected")
+	}
+}
@@ -1,44 +1,129 @@
 package security
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"fmt"
-	"os"
+	"net"
 
 	"client/internal/config"
 	"google.golang.org/grpc/credentials"
 )
 
+// Option adjusts the tls.Config of the credentials built by this package.
+type Option func(*tls.Config)
+
+// WithCRLs rejects servers whose certificate, or one of its issuers, is
+// listed in crls.
+func WithCRLs(crls *CRLSet) Option {
+	return func(c *tls.Config) {
+		c.VerifyPeerCertificate = crls.VerifyPeerCertificate
+	}
+}
+
+// LoadClientTLSCredentials applies the TLS policy of cfg and loads the
+// client key pair, CA and, if configured, CRL file once. Use NewReloader,
+// NewCRLSet and ClientCredentials to pick up rotated files.
 func LoadClientTLSCredentials(cfg *config.Config) (credentials.TransportCredentials, error) {
-	clientPair, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	r, err := NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile)
 	if err != nil {
-		return nil, fmt.Errorf(
-			"security: could not load client key pair (%s, %s): %w",
-			cfg.TLSCertFile, cfg.TLSKeyFile, err,
-		)
+		return nil, err
 	}
-
-	caPem, err := os.ReadFile(cfg.TLSCAFile)
+	policy, err := ParsePolicy(cfg)
 	if err != nil {
-		return nil, fmt.Errorf(
-			"security: could not read CA certificate file (%s): %w",
-			cfg.TLSCAFile, err,
-		)
+		return nil, err
 	}
-	rootCAs := x509.NewCertPool()
-	if ok := rootCAs.AppendCertsFromPEM(caPem); !ok {
-		return nil, fmt.Errorf(
-			"security: failed to append CA certificate(s) from %s",
-			cfg.TLSCAFile,
-		)
+	opts := []Option{WithPolicy(policy)}
+	if cfg.TLSCRLFile != "" {
+		crls, err := NewCRLSet(cfg.TLSCRLFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCRLs(crls))
 	}
+	return ClientCredentials(r, cfg.TLSServerName, opts...), nil
+}
 
+// ClientCredentials returns mTLS credentials that read the key pair and CA
+// pool from r on every handshake. If r has no key pair, no client
+// certificate is sent. serverName overrides the name checked against the
+// server certificate; empty means the dialled host.
+func ClientCredentials(r *Reloader, serverName string, opts ...Option) credentials.TransportCredentials {
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{clientPair},
-		RootCAs:      rootCAs,
-		MinVersion:   tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := r.Certificate(); cert != nil {
+				return cert, nil
+			}
+			// An empty certificate tells crypto/tls to send none.
+			return &tls.Certificate{}, nil
+		},
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+	for _, opt := range opts {
+		opt(tlsConfig)
+	}
+
+	return &observedCredentials{TransportCredentials: &reloadingCredentials{
+		TransportCredentials: credentials.NewTLS(tlsConfig),
+		config:               tlsConfig,
+		certs:                r,
+	}}
+}
+
+// reloadingCredentials sets RootCAs, which has no callback, from the
+// Reloader before each handshake so the standard verification runs against
+// the current CA bundle.
+type reloadingCredentials struct {
+	credentials.TransportCredentials
+	config *tls.Config
+	certs  *Reloader
+}
+
+func (c *reloadingCredentials) ClientHandshake(
+	ctx context.Context,
+	authority string,
+	rawConn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	cfg := c.config.Clone()
+	cfg.RootCAs = c.certs.CAPool()
+	return credentials.NewTLS(cfg).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *reloadingCredentials) Clone() credentials.TransportCredentials {
+	return &reloadingCredentials{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		config:               c.config.Clone(),
+		certs:                c.certs,
+	}
+}
+
+// OverrideServerName is deprecated in gRPC but still part of the interface.
+func (c *reloadingCredentials) OverrideServerName(serverName string) error {
+	c.config.ServerName = serverName
+	return nil
+}
+
+// observedCredentials times and classifies every client handshake.
+type observedCredentials struct {
+	credentials.TransportCredentials
+}
+
+func (c *observedCredentials) ClientHandshake(
+	ctx context.Context,
+	authority string,
+	rawConn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	handshake := startHandshake(ctx, authority, rawConn)
+	conn, info, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	if err != nil {
+		handshake.done(nil, err)
+		return nil, nil, err
 	}
+	tlsInfo, _ := info.(credentials.TLSInfo)
+	handshake.done(&tlsInfo.State, nil)
+	return conn, info, nil
+}
 
-	return credentials.NewTLS(tlsConfig), nil
+func (c *observedCredentials) Clone() credentials.TransportCredentials {
+	return &observedCredentials{TransportCredentials: c.TransportCredentials.Clone()}
 }
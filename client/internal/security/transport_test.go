@@ -0,0 +1,125 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"client/internal/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startTransportServer serves the health service with creds on a local port.
+func startTransportServer(t *testing.T, creds credentials.TransportCredentials) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer(grpc.Creds(creds))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func checkHealth(addr string, creds credentials.TransportCredentials) error {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestTransportCredentials(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "client")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	serverCert := ca.keyPair(t, "server")
+
+	// Servers by the transport they require.
+	servers := map[string]credentials.TransportCredentials{
+		"plaintext": insecure.NewCredentials(),
+		"tls":       credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{serverCert}}),
+		"mtls": credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+		}),
+	}
+
+	tests := []struct {
+		mode   string
+		server string
+		ok     bool
+	}{
+		{mode: config.TransportPlaintext, server: "plaintext", ok: true},
+		{mode: config.TransportPlaintext, server: "tls"},
+		{mode: config.TransportTLS, server: "tls", ok: true},
+		{mode: config.TransportTLS, server: "mtls"},
+		{mode: config.TransportTLS, server: "plaintext"},
+		{mode: config.TransportMTLS, server: "mtls", ok: true},
+		{mode: config.TransportMTLS, server: "tls", ok: true},
+		{mode: config.TransportMTLS, server: "plaintext"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.mode+" client/"+tc.server+" server", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			creds, err := TransportCredentials(ctx, &config.Config{
+				TransportMode: tc.mode,
+				TLSCertFile:   files.cert,
+				TLSKeyFile:    files.key,
+				TLSCAFile:     files.ca,
+				TLSServerName: "localhost",
+			})
+			if err != nil {
+				t.Fatalf("TransportCredentials returned error: %v", err)
+			}
+			addr := startTransportServer(t, servers[tc.server])
+
+			err = checkHealth(addr, creds)
+			if (err == nil) != tc.ok {
+				t.Errorf("health check: got error %v, want success=%v", err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestTransportCredentials_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{name: "unknown mode", cfg: &config.Config{TransportMode: "ssl"}},
+		{name: "SPIFFE without mTLS", cfg: &config.Config{TransportMode: config.TransportTLS, SPIFFESVIDDir: t.TempDir()}},
+		{name: "TLS without CA", cfg: &config.Config{TransportMode: config.TransportTLS, TLSCAFile: "/nonexistent/ca.crt.pem"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := TransportCredentials(context.Background(), tc.cfg); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// CheckHealth calls grpc.health.v1.Health/Check for service ("" is the
+// server as a whole) and updates the grpc_target_up gauge.
+func (cs *ClientService) CheckHealth(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	start := time.Now()
+	resp, err := cs.health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	labels := cs.record(ctx, methodHealthCheck, err)
+	cs.latency.With(labels).Observe(time.Since(start).Seconds())
+
+	st := resp.GetStatus()
+	cs.setUp(service, st)
+	return st, err
+}
+
+// WatchHealth opens a grpc.health.v1.Health/Watch stream for service and
+// calls onStatus for every status the server reports, keeping the
+// grpc_target_up gauge current. It blocks until the stream ends; cancelling
+// ctx ends it cleanly and returns nil.
+func (cs *ClientService) WatchHealth(
+	ctx context.Context,
+	service string,
+	onStatus func(healthpb.HealthCheckResponse_ServingStatus),
+) error {
+	stream, err := cs.health.Watch(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		cs.record(ctx, methodHealthWatch, err)
+		cs.setUp(service, healthpb.HealthCheckResponse_UNKNOWN)
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) || (ctx.Err() != nil && status.Code(err) == codes.Canceled) {
+				cs.record(ctx, methodHealthWatch, nil)
+				return nil
+			}
+			cs.record(ctx, methodHealthWatch, err)
+			cs.setUp(service, healthpb.HealthCheckResponse_UNKNOWN)
+			return err
+		}
+		cs.setUp(service, resp.GetStatus())
+		if onStatus != nil {
+			onStatus(resp.GetStatus())
+		}
+	}
+}
+
+// HealthCheckProbe returns a probe that calls Health/Check for service. A
+// reply other than SERVING fails the run even though the RPC returned OK.
+func (cs *ClientService) HealthCheckProbe(name, service string, interval, timeout time.Duration, expected codes.Code) Probe {
+	return NewProbe(name, cs.target, interval, timeout, expected, func(ctx context.Context) error {
+		st, err := cs.CheckHealth(ctx, service)
+		if err != nil {
+			return err
+		}
+		return servingError(service, st)
+	})
+}
+
+// HealthWatchProbe returns a probe that opens a Health/Watch stream for
+// service and checks the first status it receives.
+func (cs *ClientService) HealthWatchProbe(name, service string, interval, timeout time.Duration, expected codes.Code) Probe {
+	return NewProbe(name, cs.target, interval, timeout, expected, func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var first *healthpb.HealthCheckResponse_ServingStatus
+		err := cs.WatchHealth(ctx, service, func(st healthpb.HealthCheckResponse_ServingStatus) {
+			first = &st
+			cancel()
+		})
+		if err != nil {
+			return err
+		}
+		if first == nil {
+			// The caller cancelled before any status arrived.
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return servingError(service, *first)
+	})
+}
+
+// servingError returns nil for SERVING and an ErrUnexpectedResponse
+// otherwise.
+func servingError(service string, st healthpb.HealthCheckResponse_ServingStatus) error {
+	if st == healthpb.HealthCheckResponse_SERVING {
+		return nil
+	}
+	return fmt.Errorf("%w: service %q is %s", ErrUnexpectedResponse, service, st)
+}
+
+func (cs *ClientService) setUp(service string, st healthpb.HealthCheckResponse_ServingStatus) {
+	up := 0.0
+	if st == healthpb.HealthCheckResponse_SERVING {
+		up = 1
+	}
+	cs.targetUp.WithLabelValues(service).Set(up)
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestClientService_CheckHealth(t *testing.T) {
+	addr, cleanup := startTestGRPCServer(t)
+	defer cleanup()
+
+	svc, err := NewClientService(addr,
+		WithDialOptions(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		WithRegisterer(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatalf("NewClientService(%q) error: %v", addr, err)
+	}
+	defer svc.Close()
+
+	tests := []struct {
+		name     string
+		service  string
+		want     healthpb.HealthCheckResponse_ServingStatus
+		wantCode codes.Code
+		wantUp   float64
+	}{
+		{name: "server", service: "", want: healthpb.HealthCheckResponse_SERVING, wantUp: 1},
+		{name: "serving", service: testServingService, want: healthpb.HealthCheckResponse_SERVING, wantUp: 1},
+		{name: "not serving", service: testNotServingService, want: healthpb.HealthCheckResponse_NOT_SERVING, wantUp: 0},
+		{name: "unknown service", service: "No.Such.Service", wantCode: codes.NotFound, wantUp: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := svc.CheckHealth(context.Background(), tc.service)
+			if code := codeOf(err); code != tc.wantCode {
+				t.Fatalf("CheckHealth(%q) code: got %s, want %s (err: %v)", tc.service, code, tc.wantCode, err)
+			}
+			if err == nil && got != tc.want {
+				t.Errorf("CheckHealth(%q): got %s, want %s", tc.service, got, tc.want)
+			}
+			if up := testutil.ToFloat64(svc.targetUp.WithLabelValues(tc.service)); up != tc.wantUp {
+				t.Errorf("grpc_target_up{service=%q}: got %v, want %v", tc.service, up, tc.wantUp)
+			}
+		})
+	}
+}
+
+func TestClientService_HealthProbes(t *testing.T) {
+	addr, cleanup := startTestGRPCServer(t)
+	defer cleanup()
+
+	svc, err := NewClientService(addr,
+		WithDialOptions(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		WithRegisterer(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatalf("NewClientService(%q) error: %v", addr, err)
+	}
+	defer svc.Close()
+
+	s := newTestScheduler(t)
+	timeout := 2 * time.Second
+
+	tests := []struct {
+		name        string
+		probe       Probe
+		wantCode    codes.Code
+		wantSuccess bool
+	}{
+		{
+			name:        "check serving",
+			probe:       svc.HealthCheckProbe("check", testServingService, time.Second, timeout, codes.OK),
+			wantCode:    codes.OK,
+			wantSuccess: true,
+		},
+		{
+			name:     "check not serving",
+			probe:    svc.HealthCheckProbe("check-draining", testNotServingService, time.Second, timeout, codes.OK),
+			wantCode: codes.OK,
+		},
+		{
+			name:        "check unknown service expected",
+			probe:       svc.HealthCheckProbe("check-missing", "No.Such.Service", time.Second, timeout, codes.NotFound),
+			wantCode:    codes.NotFound,
+			wantSuccess: true,
+		},
+		{
+			name:        "watch serving",
+			probe:       svc.HealthWatchProbe("watch", testServingService, time.Second, timeout, codes.OK),
+			wantCode:    codes.OK,
+			wantSuccess: true,
+		},
+		{
+			name:     "watch not serving",
+			probe:    svc.HealthWatchProbe("watch-draining", testNotServingService, time.Second, timeout, codes.OK),
+			wantCode: codes.OK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := s.RunOnce(context.Background(), tc.probe)
+			if res.Code != tc.wantCode {
+				t.Errorf("code: got %s, want %s (err: %v)", res.Code, tc.wantCode, res.Err)
+			}
+			if res.Success != tc.wantSuccess {
+				t.Errorf("success: got %v, want %v (err: %v)", res.Success, tc.wantSuccess, res.Err)
+			}
+			if !tc.wantSuccess && tc.wantCode == codes.OK && !errors.Is(res.Err, ErrUnexpectedResponse) {
+				t.Errorf("expected ErrUnexpectedResponse for a non-SERVING reply, got %v", res.Err)
+			}
+		})
+	}
+
+	if up := testutil.ToFloat64(svc.targetUp.WithLabelValues(testNotServingService)); up != 0 {
+		t.Errorf("grpc_target_up for %s: got %v, want 0", testNotServingService, up)
+	}
+	if up := testutil.ToFloat64(svc.targetUp.WithLabelValues(testServingService)); up != 1 {
+		t.Errorf("grpc_target_up for %s: got %v, want 1", testServingService, up)
+	}
+}
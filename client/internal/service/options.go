@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// Option configures a ClientService or a Scheduler.
+type Option func(*options)
+
+type options struct {
+	dialOpts    []grpc.DialOption
+	registerer  prometheus.Registerer
+	namespace   string
+	subsystem   string
+	constLabels prometheus.Labels
+
+	failureThreshold int
+}
+
+// DefaultFailureThreshold is how many consecutive failed runs mark a probe
+// as failing for Scheduler.Ready and Scheduler.Live.
+const DefaultFailureThreshold = 3
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		registerer:       prometheus.DefaultRegisterer,
+		failureThreshold: DefaultFailureThreshold,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithDialOptions sets the options used to dial the server. Transport
+// credentials are required by grpc.NewClient.
+func WithDialOptions(dialOpts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOpts = append(o.dialOpts, dialOpts...)
+	}
+}
+
+// WithRegisterer registers metrics with reg instead of the global
+// prometheus.DefaultRegisterer.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registerer = reg
+	}
+}
+
+// WithNamespace prefixes every metric name with namespace.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithSubsystem adds subsystem between the namespace and the metric name.
+func WithSubsystem(subsystem string) Option {
+	return func(o *options) {
+		o.subsystem = subsystem
+	}
+}
+
+// WithConstLabels attaches labels to every metric.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) {
+		o.constLabels = labels
+	}
+}
+
+// WithFailureThreshold sets how many consecutive failed runs mark a probe
+// as failing. It only applies to a Scheduler; values below 1 are ignored.
+func WithFailureThreshold(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.failureThreshold = n
+		}
+	}
+}
+
+func (o *options) counterOpts(name, help string) prometheus.CounterOpts {
+	return prometheus.CounterOpts{
+		Namespace:   o.namespace,
+		Subsystem:   o.subsystem,
+		Name:        name,
+		Help:        help,
+		ConstLabels: o.constLabels,
+	}
+}
+
+func (o *options) gaugeOpts(name, help string) prometheus.GaugeOpts {
+	return prometheus.GaugeOpts{
+		Namespace:   o.namespace,
+		Subsystem:   o.subsystem,
+		Name:        name,
+		Help:        help,
+		ConstLabels: o.constLabels,
+	}
+}
+
+func (o *options) histogramOpts(name, help string, buckets []float64) prometheus.HistogramOpts {
+	return prometheus.HistogramOpts{
+		Namespace:   o.namespace,
+		Subsystem:   o.subsystem,
+		Name:        name,
+		Help:        help,
+		ConstLabels: o.constLabels,
+		Buckets:     buckets,
+	}
+}
+
+// mustRegisterOrReuse registers c with reg, or returns the collector already
+// registered under the same name so that several services can share it.
+func mustRegisterOrReuse[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
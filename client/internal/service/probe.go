@@ -0,0 +1,437 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"client/internal/logging"
+)
+
+// Probe is a synthetic check that a Scheduler runs on a fixed interval.
+type Probe interface {
+	Name() string
+	// Target identifies the server the probe checks; the same probe name may
+	// be registered once per target.
+	Target() string
+	Interval() time.Duration
+	// Timeout bounds a single Run; zero means no deadline.
+	Timeout() time.Duration
+	// ExpectedCode is the gRPC status code a healthy Run returns.
+	ExpectedCode() codes.Code
+	// Run performs one check. A nil error counts as codes.OK.
+	Run(ctx context.Context) error
+}
+
+// NewProbe builds a Probe from a plain function.
+func NewProbe(
+	name, target string,
+	interval, timeout time.Duration,
+	expected codes.Code,
+	run func(context.Context) error,
+) Probe {
+	return &funcProbe{
+		name:     name,
+		target:   target,
+		interval: interval,
+		timeout:  timeout,
+		expected: expected,
+		run:      run,
+	}
+}
+
+type funcProbe struct {
+	name     string
+	target   string
+	interval time.Duration
+	timeout  time.Duration
+	expected codes.Code
+	run      func(context.Context) error
+}
+
+func (p *funcProbe) Name() string                  { return p.name }
+func (p *funcProbe) Target() string                { return p.target }
+func (p *funcProbe) Interval() time.Duration       { return p.interval }
+func (p *funcProbe) Timeout() time.Duration        { return p.timeout }
+func (p *funcProbe) ExpectedCode() codes.Code      { return p.expected }
+func (p *funcProbe) Run(ctx context.Context) error { return p.run(ctx) }
+
+type probeContextKey struct{}
+
+// WithProbe marks ctx as belonging to a run of the named probe, so client
+// metrics recorded under it carry the probe label.
+func WithProbe(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, probeContextKey{}, name)
+}
+
+// ProbeFromContext returns the probe name set by WithProbe, or "".
+func ProbeFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(probeContextKey{}).(string)
+	return name
+}
+
+// ProbeResult is the outcome of a single probe run.
+type ProbeResult struct {
+	Probe    string
+	Target   string
+	Code     codes.Code
+	Err      error
+	Duration time.Duration
+	// Success reports whether Code matched the probe's ExpectedCode.
+	Success bool
+}
+
+// Scheduler runs every registered probe on its own ticker, recording
+// per-probe metrics and one trace span per run.
+type Scheduler struct {
+	mu     sync.Mutex
+	probes []Probe
+	// failures counts consecutive failed runs per probe; a probe that has
+	// not run yet has no entry.
+	failures  map[probeKey]int
+	threshold int
+	// runCtx is the context passed to Run while it is active, stop stops
+	// the loops of the current probes and current tracks them; loops
+	// tracks every loop, including those of replaced probes.
+	runCtx  context.Context
+	stop    chan struct{}
+	current *sync.WaitGroup
+	loops   sync.WaitGroup
+
+	runs     *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	up       *prometheus.GaugeVec
+	tracer   trace.Tracer
+}
+
+// NewScheduler creates an empty scheduler. Only the metric options
+// (WithRegisterer, WithNamespace, ...) and WithFailureThreshold apply to it.
+func NewScheduler(opts ...Option) *Scheduler {
+	o := newOptions(opts)
+
+	runs := prometheus.NewCounterVec(o.counterOpts(
+		"grpc_client_probe_runs_total",
+		"Number of probe runs by probe, returned gRPC code and result (success when the code matched the expected one)",
+	), []string{"probe", "target", "code", "result"})
+	duration := prometheus.NewHistogramVec(o.histogramOpts(
+		"grpc_client_probe_duration_seconds",
+		"Duration of probe runs",
+		latencyBuckets,
+	), []string{"probe", "target"})
+	up := prometheus.NewGaugeVec(o.gaugeOpts(
+		"grpc_client_probe_success",
+		"Whether the last run of the probe returned the expected code (1) or not (0)",
+	), []string{"probe", "target"})
+
+	o.registerer.MustRegister(runs, duration, up)
+
+	return &Scheduler{
+		failures:  make(map[probeKey]int),
+		threshold: o.failureThreshold,
+		runs:      runs,
+		duration:  duration,
+		up:        up,
+		tracer:    otel.Tracer("client/internal/service"),
+	}
+}
+
+// Register adds p to the scheduler. Probes must have a non-empty name that
+// is unique per target and a positive interval. Probes registered after Run
+// has started are not picked up; use Replace for that.
+func (s *Scheduler) Register(p Probe) error {
+	if err := validateProbe(p); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.probes {
+		if existing.Name() == p.Name() && existing.Target() == p.Target() {
+			return fmt.Errorf("probe %q is already registered for target %q", p.Name(), p.Target())
+		}
+	}
+	s.probes = append(s.probes, p)
+	return nil
+}
+
+func validateProbe(p Probe) error {
+	if p.Name() == "" {
+		return errors.New("probe name must not be empty")
+	}
+	if p.Interval() <= 0 {
+		return fmt.Errorf("probe %q: interval must be positive, got %s", p.Name(), p.Interval())
+	}
+	return nil
+}
+
+// Replace swaps every registered probe for probes, which are checked like
+// in Register; on error nothing changes. If Run is active, the new probes
+// start at once and Replace returns once the old ones have finished their
+// current run, so that what they use can then be released. Counters, and
+// the failure counts behind Ready and Live, carry over for probes with the
+// same name and target; every series of a removed probe is dropped.
+func (s *Scheduler) Replace(probes []Probe) error {
+	seen := make(map[probeKey]bool, len(probes))
+	for _, p := range probes {
+		if err := validateProbe(p); err != nil {
+			return err
+		}
+		key := probeKey{p.Name(), p.Target()}
+		if seen[key] {
+			return fmt.Errorf("probe %q is defined twice for target %q", p.Name(), p.Target())
+		}
+		seen[key] = true
+	}
+
+	s.mu.Lock()
+	var removed []probeKey
+	for _, p := range s.probes {
+		if key := (probeKey{p.Name(), p.Target()}); !seen[key] {
+			removed = append(removed, key)
+		}
+	}
+	s.probes = append([]Probe(nil), probes...)
+	var old *sync.WaitGroup
+	if s.runCtx != nil {
+		close(s.stop)
+		old = s.current
+		s.startLocked()
+	}
+	s.mu.Unlock()
+
+	// A run still in progress would record the removed probes again.
+	if old != nil {
+		old.Wait()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range removed {
+		if !s.registeredLocked(key) {
+			delete(s.failures, key)
+			labels := prometheus.Labels{"probe": key.name, "target": key.target}
+			s.runs.DeletePartialMatch(labels)
+			s.duration.DeletePartialMatch(labels)
+			s.up.DeletePartialMatch(labels)
+		}
+	}
+	return nil
+}
+
+// registeredLocked reports whether a probe with key is registered. s.mu
+// must be held.
+func (s *Scheduler) registeredLocked(key probeKey) bool {
+	for _, p := range s.probes {
+		if p.Name() == key.name && p.Target() == key.target {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFailureThreshold changes the number of consecutive failed runs after
+// which Ready and Live report a probe as failing. Values below 1 are
+// ignored.
+func (s *Scheduler) SetFailureThreshold(n int) {
+	if n < 1 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.threshold = n
+}
+
+// Run starts every registered probe and blocks until ctx is cancelled and
+// all probe goroutines have returned.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.runCtx = ctx
+	s.startLocked()
+	s.mu.Unlock()
+
+	<-ctx.Done()
+	s.mu.Lock()
+	s.runCtx = nil
+	s.mu.Unlock()
+	s.loops.Wait()
+}
+
+// startLocked starts a loop for each probe. s.mu must be held.
+func (s *Scheduler) startLocked() {
+	ctx, stop, current := s.runCtx, make(chan struct{}), &sync.WaitGroup{}
+	s.stop, s.current = stop, current
+	for _, p := range s.probes {
+		s.loops.Add(1)
+		current.Add(1)
+		go func() {
+			defer s.loops.Done()
+			defer current.Done()
+			s.loop(ctx, stop, p)
+		}()
+	}
+}
+
+// loop runs p every interval until ctx is cancelled or stop is closed. A
+// run in progress when stop is closed completes normally.
+func (s *Scheduler) loop(ctx context.Context, stop <-chan struct{}, p Probe) {
+	ticker := time.NewTicker(p.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		// select picks at random when the ticker fired too; do not start
+		// another run once stopped.
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		res := s.RunOnce(ctx, p)
+		if res.Success {
+			logging.Debugf("[PROBE %s@%s] ok (%s) in %s", res.Probe, res.Target, res.Code, res.Duration)
+		} else {
+			log.Printf("[PROBE %s@%s] failed (expected %s, got %s): %v",
+				res.Probe, res.Target, p.ExpectedCode(), res.Code, res.Err)
+		}
+	}
+}
+
+// RunOnce runs p a single time inside its own span and records the result.
+func (s *Scheduler) RunOnce(ctx context.Context, p Probe) ProbeResult {
+	ctx = WithProbe(ctx, p.Name())
+	ctx, span := s.tracer.Start(ctx, "probe "+p.Name(), trace.WithAttributes(
+		attribute.String("probe.name", p.Name()),
+		attribute.String("probe.target", p.Target()),
+		attribute.String("probe.expected_code", p.ExpectedCode().String()),
+	))
+	defer span.End()
+
+	if timeout := p.Timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := p.Run(ctx)
+	res := ProbeResult{
+		Probe:    p.Name(),
+		Target:   p.Target(),
+		Code:     codeOf(err),
+		Err:      err,
+		Duration: time.Since(start),
+	}
+	res.Success = res.Code == p.ExpectedCode() && !errors.Is(err, ErrUnexpectedResponse)
+
+	result := "success"
+	if !res.Success {
+		result = "failure"
+		span.SetStatus(otelcodes.Error, fmt.Sprintf("expected %s, got %s: %v", p.ExpectedCode(), res.Code, err))
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+	span.SetAttributes(attribute.String("rpc.grpc.status_code", res.Code.String()))
+
+	s.runs.WithLabelValues(p.Name(), p.Target(), res.Code.String(), result).Inc()
+	s.duration.WithLabelValues(p.Name(), p.Target()).Observe(res.Duration.Seconds())
+	if res.Success {
+		s.up.WithLabelValues(p.Name(), p.Target()).Set(1)
+	} else {
+		s.up.WithLabelValues(p.Name(), p.Target()).Set(0)
+	}
+
+	key := probeKey{p.Name(), p.Target()}
+	s.mu.Lock()
+	if res.Success {
+		s.failures[key] = 0
+	} else {
+		s.failures[key]++
+	}
+	s.mu.Unlock()
+	return res
+}
+
+type probeKey struct {
+	name, target string
+}
+
+func (k probeKey) String() string {
+	return k.name + "@" + k.target
+}
+
+// Ready returns nil once every registered probe has run and none has failed
+// its last threshold runs in a row. The error lists the offending probes.
+func (s *Scheduler) Ready() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, p := range s.probes {
+		key := probeKey{p.Name(), p.Target()}
+		failures, ran := s.failures[key]
+		switch {
+		case !ran:
+			errs = append(errs, fmt.Errorf("probe %s has not run yet", key))
+		case failures >= s.threshold:
+			errs = append(errs, fmt.Errorf("probe %s failed its last %d runs", key, failures))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Live returns an error only when every registered probe that has run
+// failed its last threshold runs in a row, i.e. the client cannot reach
+// anything it checks.
+func (s *Scheduler) Live() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ran := 0
+	for _, p := range s.probes {
+		failures, ok := s.failures[probeKey{p.Name(), p.Target()}]
+		if !ok {
+			continue
+		}
+		if failures < s.threshold {
+			return nil
+		}
+		ran++
+	}
+	if ran == 0 {
+		return nil
+	}
+	return fmt.Errorf("all %d probes failed their last %d runs", ran, s.threshold)
+}
+
+// codeOf maps err to a gRPC code, treating bare context errors from
+// non-RPC probes the same way gRPC would. A response mismatch on a
+// successful RPC keeps the code OK.
+func codeOf(err error) codes.Code {
+	if _, ok := status.FromError(err); !ok {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+			return status.FromContextError(err).Code()
+		case errors.Is(err, ErrUnexpectedResponse):
+			return codes.OK
+		}
+	}
+	return status.Code(err)
+}
@@ -0,0 +1,451 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func newTestScheduler(t *testing.T) *Scheduler {
+	t.Helper()
+
+	return NewScheduler(WithRegisterer(prometheus.NewRegistry()))
+}
+
+func TestScheduler_Register(t *testing.T) {
+	s := newTestScheduler(t)
+	noop := func(context.Context) error { return nil }
+
+	if err := s.Register(NewProbe("ping", "test", time.Second, 0, codes.OK, noop)); err != nil {
+		t.Fatalf("Register returned error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		probe Probe
+	}{
+		{name: "empty name", probe: NewProbe("", "test", time.Second, 0, codes.OK, noop)},
+		{name: "zero interval", probe: NewProbe("zero", "test", 0, 0, codes.OK, noop)},
+		{name: "duplicate name", probe: NewProbe("ping", "test", time.Minute, 0, codes.OK, noop)},
+	}
+
+	if err := s.Register(NewProbe("ping", "other", time.Second, 0, codes.OK, noop)); err != nil {
+		t.Errorf("expected the same probe name to be accepted for another target, got: %v", err)
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := s.Register(tc.probe); err == nil {
+				t.Errorf("expected Register to fail for %s", tc.name)
+			}
+		})
+	}
+}
+
+func TestScheduler_RunOnce(t *testing.T) {
+	s := newTestScheduler(t)
+
+	tests := []struct {
+		name        string
+		expected    codes.Code
+		timeout     time.Duration
+		run         func(context.Context) error
+		wantCode    codes.Code
+		wantSuccess bool
+	}{
+		{
+			name:        "ok as expected",
+			expected:    codes.OK,
+			run:         func(context.Context) error { return nil },
+			wantCode:    codes.OK,
+			wantSuccess: true,
+		},
+		{
+			name:        "expected error code",
+			expected:    codes.InvalidArgument,
+			run:         func(context.Context) error { return status.Error(codes.InvalidArgument, "bad") },
+			wantCode:    codes.InvalidArgument,
+			wantSuccess: true,
+		},
+		{
+			name:     "unexpected error code",
+			expected: codes.InvalidArgument,
+			run:      func(context.Context) error { return status.Error(codes.Unavailable, "down") },
+			wantCode: codes.Unavailable,
+		},
+		{
+			name:     "timeout",
+			expected: codes.OK,
+			timeout:  10 * time.Millisecond,
+			run: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			wantCode: codes.DeadlineExceeded,
+		},
+		{
+			name:     "plain error",
+			expected: codes.OK,
+			run:      func(context.Context) error { return errors.New("boom") },
+			wantCode: codes.Unknown,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewProbe(tc.name, "test", time.Second, tc.timeout, tc.expected, tc.run)
+			res := s.RunOnce(context.Background(), p)
+
+			if res.Code != tc.wantCode {
+				t.Errorf("unexpected code: got %s, want %s", res.Code, tc.wantCode)
+			}
+			if res.Success != tc.wantSuccess {
+				t.Errorf("unexpected success: got %v, want %v", res.Success, tc.wantSuccess)
+			}
+
+			result, wantUp := "failure", 0.0
+			if tc.wantSuccess {
+				result, wantUp = "success", 1.0
+			}
+			if got := testutil.ToFloat64(s.runs.WithLabelValues(tc.name, "test", tc.wantCode.String(), result)); got != 1 {
+				t.Errorf("expected 1 %s run recorded, got %v", result, got)
+			}
+			if got := testutil.ToFloat64(s.up.WithLabelValues(tc.name, "test")); got != wantUp {
+				t.Errorf("unexpected probe success gauge: got %v, want %v", got, wantUp)
+			}
+		})
+	}
+}
+
+func TestScheduler_Run(t *testing.T) {
+	s := newTestScheduler(t)
+
+	var fast, slow atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	probes := []Probe{
+		NewProbe("fast", "test", 5*time.Millisecond, time.Second, codes.OK, func(context.Context) error {
+			if fast.Add(1) == 3 {
+				cancel()
+			}
+			return nil
+		}),
+		NewProbe("slow", "test", time.Hour, time.Second, codes.OK, func(context.Context) error {
+			slow.Add(1)
+			return nil
+		}),
+	}
+	for _, p := range probes {
+		if err := s.Register(p); err != nil {
+			t.Fatalf("Register(%q) returned error: %v", p.Name(), err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+
+	if got := fast.Load(); got < 3 {
+		t.Errorf("expected fast probe to run at least 3 times, got %d", got)
+	}
+	if got := slow.Load(); got != 0 {
+		t.Errorf("expected slow probe not to run yet, got %d runs", got)
+	}
+}
+
+func TestScheduler_Replace(t *testing.T) {
+	s := newTestScheduler(t)
+
+	var oldA, newA, c atomic.Int32
+	count := func(n *atomic.Int32) func(context.Context) error {
+		return func(context.Context) error {
+			n.Add(1)
+			return nil
+		}
+	}
+	for _, p := range []Probe{
+		NewProbe("a", "test", 5*time.Millisecond, time.Second, codes.OK, count(&oldA)),
+		NewProbe("b", "test", 5*time.Millisecond, time.Second, codes.OK, func(context.Context) error { return nil }),
+	} {
+		if err := s.Register(p); err != nil {
+			t.Fatalf("Register(%q) returned error: %v", p.Name(), err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor("the first runs", func() bool {
+		return s.Ready() == nil
+	})
+
+	bad := []Probe{
+		NewProbe("c", "test", time.Second, 0, codes.OK, count(&c)),
+		NewProbe("c", "test", time.Minute, 0, codes.OK, count(&c)),
+	}
+	if err := s.Replace(bad); err == nil {
+		t.Fatal("expected Replace to reject a duplicate probe")
+	}
+
+	if err := s.Replace([]Probe{
+		NewProbe("a", "test", 5*time.Millisecond, time.Second, codes.OK, count(&newA)),
+		NewProbe("c", "test", 5*time.Millisecond, time.Second, codes.OK, count(&c)),
+	}); err != nil {
+		t.Fatalf("Replace returned error: %v", err)
+	}
+	stoppedAt := oldA.Load()
+	waitFor("the new probes to run", func() bool {
+		return newA.Load() > 0 && c.Load() > 0
+	})
+
+	// The old loop may finish the run it was in when Replace was called.
+	if got := oldA.Load(); got > stoppedAt+1 {
+		t.Errorf("expected the replaced probe to stop, it ran %d more times", got-stoppedAt)
+	}
+	if got := testutil.ToFloat64(s.runs.WithLabelValues("a", "test", "OK", "success")); got < float64(stoppedAt+newA.Load()) {
+		t.Errorf("expected the run counter of a to carry over, got %v", got)
+	}
+	removed := prometheus.Labels{"probe": "b", "target": "test"}
+	for name, vec := range map[string]interface {
+		DeletePartialMatch(prometheus.Labels) int
+	}{
+		"grpc_client_probe_runs_total":       s.runs,
+		"grpc_client_probe_duration_seconds": s.duration,
+		"grpc_client_probe_success":          s.up,
+	} {
+		if n := vec.DeletePartialMatch(removed); n != 0 {
+			t.Errorf("got %d %s series of the removed probe, want none", n, name)
+		}
+	}
+	waitFor("the replaced probes to be ready", func() bool {
+		return s.Ready() == nil
+	})
+}
+
+func TestScheduler_ReplaceBlockedRun(t *testing.T) {
+	s := newTestScheduler(t)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var once atomic.Bool
+	blocked := NewProbe("blocked", "test", 5*time.Millisecond, time.Minute, codes.OK, func(ctx context.Context) error {
+		if once.CompareAndSwap(false, true) {
+			close(started)
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		// The connection the probe used is closed once it is replaced.
+		return status.Error(codes.Canceled, "connection closing")
+	})
+	if err := s.Register(blocked); err != nil {
+		t.Fatalf("Register returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	<-started
+
+	replaced := make(chan error, 1)
+	go func() { replaced <- s.Replace(nil) }()
+	select {
+	case err := <-replaced:
+		t.Fatalf("Replace returned during the run of the removed probe: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-replaced:
+		if err != nil {
+			t.Fatalf("Replace returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replace did not return after the run completed")
+	}
+	if n := testutil.CollectAndCount(s.up); n != 0 {
+		t.Errorf("got %d grpc_client_probe_success series after the probe was removed, want none", n)
+	}
+	if err := s.Ready(); err != nil {
+		t.Errorf("Ready returned error with no probes left: %v", err)
+	}
+}
+
+func TestClientService_Probes(t *testing.T) {
+	addr, cleanup := startTestGRPCServer(t)
+	defer cleanup()
+
+	clientSvc, err := NewClientService(addr,
+		WithDialOptions(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		WithRegisterer(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatalf("NewClientService(%q) error: %v", addr, err)
+	}
+	defer clientSvc.Close()
+
+	s := newTestScheduler(t)
+
+	tests := []struct {
+		name        string
+		probe       Probe
+		wantCode    codes.Code
+		wantSuccess bool
+	}{
+		{
+			name:        "ping matches pattern",
+			probe:       clientSvc.MonitoringProbe("ping", "ping", time.Second, time.Second, codes.OK, regexp.MustCompile(`response: pong$`)),
+			wantCode:    codes.OK,
+			wantSuccess: true,
+		},
+		{
+			name:     "ping does not match pattern",
+			probe:    clientSvc.MonitoringProbe("ping-mismatch", "ping", time.Second, time.Second, codes.OK, regexp.MustCompile(`^pong`)),
+			wantCode: codes.OK,
+		},
+		{
+			name:        "wrong matches status message",
+			probe:       clientSvc.MonitoringProbe("wrong", "wrong", time.Second, time.Second, codes.InvalidArgument, regexp.MustCompile(`invalid message`)),
+			wantCode:    codes.InvalidArgument,
+			wantSuccess: true,
+		},
+		{
+			name:     "wrong with unexpected status message",
+			probe:    clientSvc.MonitoringProbe("wrong-mismatch", "wrong", time.Second, time.Second, codes.InvalidArgument, regexp.MustCompile(`^nope`)),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:        "watch first heartbeat",
+			probe:       clientSvc.WatchProbe("watch", time.Second, time.Second, codes.OK),
+			wantCode:    codes.OK,
+			wantSuccess: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := s.RunOnce(context.Background(), tc.probe)
+			if res.Code != tc.wantCode || res.Success != tc.wantSuccess {
+				t.Errorf("got code=%s success=%v (err: %v), want code=%s success=%v",
+					res.Code, res.Success, res.Err, tc.wantCode, tc.wantSuccess)
+			}
+		})
+	}
+
+	// Client metrics carry the probe name, so the expected InvalidArgument
+	// of "wrong" is kept apart from any other failure.
+	wrong := prometheus.Labels{"method": "Monitoring", "probe": "wrong", "code": "InvalidArgument"}
+	if got := testutil.ToFloat64(clientSvc.failureCalls.With(wrong)); got != 1 {
+		t.Errorf("expected 1 InvalidArgument failure for probe wrong, got %v", got)
+	}
+	watch := prometheus.Labels{"method": "Watch", "probe": "watch", "code": "OK"}
+	if got := testutil.ToFloat64(clientSvc.successCalls.With(watch)); got != 1 {
+		t.Errorf("expected 1 successful Watch for probe watch, got %v", got)
+	}
+}
+
+func TestScheduler_ReadyAndLive(t *testing.T) {
+	s := NewScheduler(WithRegisterer(prometheus.NewRegistry()), WithFailureThreshold(2))
+
+	var aFails, bFails atomic.Bool
+	a := NewProbe("a", "test", time.Second, 0, codes.OK, func(context.Context) error {
+		if aFails.Load() {
+			return status.Error(codes.Unavailable, "down")
+		}
+		return nil
+	})
+	b := NewProbe("b", "test", time.Second, 0, codes.OK, func(context.Context) error {
+		if bFails.Load() {
+			return status.Error(codes.Unavailable, "down")
+		}
+		return nil
+	})
+	for _, p := range []Probe{a, b} {
+		if err := s.Register(p); err != nil {
+			t.Fatalf("Register(%s) returned error: %v", p.Name(), err)
+		}
+	}
+
+	if err := s.Ready(); err == nil {
+		t.Error("expected Ready to fail before any probe has run")
+	}
+	if err := s.Live(); err != nil {
+		t.Errorf("expected Live before any probe has run, got: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		aFails    bool
+		bFails    bool
+		runs      int
+		wantReady bool
+		wantLive  bool
+	}{
+		{name: "all succeed", runs: 1, wantReady: true, wantLive: true},
+		{name: "one failure is below the threshold", aFails: true, runs: 1, wantReady: true, wantLive: true},
+		{name: "one probe keeps failing", aFails: true, runs: 1, wantReady: false, wantLive: true},
+		{name: "every probe keeps failing", aFails: true, bFails: true, runs: 2, wantReady: false, wantLive: false},
+		{name: "a success resets the count", runs: 1, wantReady: true, wantLive: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			aFails.Store(tc.aFails)
+			bFails.Store(tc.bFails)
+			for range tc.runs {
+				s.RunOnce(context.Background(), a)
+				s.RunOnce(context.Background(), b)
+			}
+
+			if err := s.Ready(); (err == nil) != tc.wantReady {
+				t.Errorf("Ready: got %v, want ready=%v", err, tc.wantReady)
+			}
+			if err := s.Live(); (err == nil) != tc.wantLive {
+				t.Errorf("Live: got %v, want live=%v", err, tc.wantLive)
+			}
+		})
+	}
+}
@@ -3,96 +3,343 @@ package service
 import (
 	monitoringpb "client/internal/pb/monitoring"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"regexp"
+	"time"
+)
+
+// latencyBuckets span 0.5ms to ~4s, which covers a healthy LAN as well as a
+// stream stuck behind a slow message.
+var latencyBuckets = prometheus.ExponentialBuckets(0.0005, 2, 14)
+
+// watchProbeInterval is the heartbeat interval a WatchProbe asks for; the
+// probe only waits for the first one, so it is kept short.
+const watchProbeInterval = 100 * time.Millisecond
+
+// ErrUnexpectedResponse marks probe runs whose reply did not match the
+// expected pattern. Such runs fail even if the status code was expected.
+var ErrUnexpectedResponse = errors.New("unexpected response")
+
+// RPC method names used as the "method" label of client metrics.
+const (
+	methodMonitoring = "Monitoring"
+	methodWatch      = "Watch"
+	methodPingStream = "PingStream"
+	// Methods of the standard grpc.health.v1.Health service.
+	methodHealthCheck = "Health/Check"
+	methodHealthWatch = "Health/Watch"
 )
 
 type ClientService struct {
-	conn         *grpc.ClientConn
-	client       monitoringpb.MonitoringServiceClient
-	totalCalls   prometheus.Counter
-	successCalls prometheus.Counter
-	failureCalls prometheus.Counter
+	conn   *grpc.ClientConn
+	client monitoringpb.MonitoringServiceClient
+	health healthpb.HealthClient
+	target string
+
+	// Request metrics are curried with this service's target; the remaining
+	// labels are method, probe and code.
+	totalCalls    *prometheus.CounterVec
+	successCalls  *prometheus.CounterVec
+	failureCalls  *prometheus.CounterVec
+	latency       prometheus.ObserverVec
+	oneWayLatency prometheus.ObserverVec
+	roundTrip     prometheus.Observer
+	targetUp      *prometheus.GaugeVec
+}
+
+// PingLatency is the timing of a single PingStream message. The one-way
+// values compare client and server clocks, so they are only as accurate as
+// the clock sync between the two hosts; RoundTrip uses the client clock only.
+type PingLatency struct {
+	ClientToServer time.Duration
+	ServerToClient time.Duration
+	RoundTrip      time.Duration
 }
 
-func NewClientService(serverAddr string, dialOpts ...grpc.DialOption) (*ClientService, error) {
+// NewClientService dials serverAddr. Dial options are passed with
+// WithDialOptions; metrics go to prometheus.DefaultRegisterer unless
+// WithRegisterer is given.
+func NewClientService(serverAddr string, opts ...Option) (*ClientService, error) {
+	o := newOptions(opts)
 	target := "dns:///" + serverAddr
 
-	grpcConn, err := grpc.NewClient(target, dialOpts...)
+	grpcConn, err := grpc.NewClient(target, o.dialOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("grpc.NewClient(%q) returned error: %w", serverAddr, err)
 	}
 
 	client := monitoringpb.NewMonitoringServiceClient(grpcConn)
 
-	total := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "grpc_client_total_requests",
-		Help: "Total number of gRPC requests sent by the client",
-	})
-	success := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "grpc_client_success_requests",
-		Help: "Number of successful gRPC requests",
-	})
-	failure := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "grpc_client_failed_requests",
-		Help: "Number of failed gRPC requests",
-	})
+	// Every service labels its series with its own target; services for
+	// other targets share the same registered vectors.
+	reg := o.registerer
+	requestLabels := []string{"method", "probe", "target", "code"}
+	total := mustRegisterOrReuse(reg, prometheus.NewCounterVec(o.counterOpts(
+		"grpc_client_total_requests",
+		"Total number of gRPC requests sent by the client, by method, probe, target and status code",
+	), requestLabels))
+	success := mustRegisterOrReuse(reg, prometheus.NewCounterVec(o.counterOpts(
+		"grpc_client_success_requests",
+		"Number of successful gRPC requests",
+	), requestLabels))
+	failure := mustRegisterOrReuse(reg, prometheus.NewCounterVec(o.counterOpts(
+		"grpc_client_failed_requests",
+		"Number of failed gRPC requests; the code label tells expected errors from outages",
+	), requestLabels))
+	latency := mustRegisterOrReuse(reg, prometheus.NewHistogramVec(o.histogramOpts(
+		"grpc_client_request_duration_seconds",
+		"Latency of unary gRPC requests measured by the client",
+		latencyBuckets,
+	), requestLabels))
 
-	prometheus.MustRegister(total, success, failure)
+	oneWay := mustRegisterOrReuse(reg, prometheus.NewHistogramVec(o.histogramOpts(
+		"grpc_client_ping_stream_one_way_latency_seconds",
+		"One-way latency of PingStream messages by direction (depends on client/server clock sync)",
+		latencyBuckets,
+	), []string{"target", "direction"}))
+	roundTrip := mustRegisterOrReuse(reg, prometheus.NewHistogramVec(o.histogramOpts(
+		"grpc_client_ping_stream_round_trip_seconds",
+		"Round-trip latency of PingStream messages measured on the client",
+		latencyBuckets,
+	), []string{"target"}))
+	targetUp := mustRegisterOrReuse(reg, prometheus.NewGaugeVec(o.gaugeOpts(
+		"grpc_target_up",
+		"Whether the last health check of the service on the target returned SERVING (1) or not (0)",
+	), []string{"target", "service"}))
 
+	targetLabel := prometheus.Labels{"target": serverAddr}
 	return &ClientService{
-		conn:         grpcConn,
-		client:       client,
-		totalCalls:   total,
-		successCalls: success,
-		failureCalls: failure,
+		conn:          grpcConn,
+		client:        client,
+		health:        healthpb.NewHealthClient(grpcConn),
+		target:        serverAddr,
+		totalCalls:    total.MustCurryWith(targetLabel),
+		successCalls:  success.MustCurryWith(targetLabel),
+		failureCalls:  failure.MustCurryWith(targetLabel),
+		latency:       latency.MustCurryWith(targetLabel),
+		oneWayLatency: oneWay.MustCurryWith(targetLabel),
+		roundTrip:     roundTrip.With(targetLabel),
+		targetUp:      targetUp.MustCurryWith(targetLabel),
 	}, nil
 }
 
+// Target returns the server address this service talks to.
+func (cs *ClientService) Target() string {
+	return cs.target
+}
+
 func (cs *ClientService) Close() error {
 	return cs.conn.Close()
 }
 
-func (cs *ClientService) SendPing(ctx context.Context) (string, error) {
-	cs.totalCalls.Inc()
+// record counts one finished call. err is the call's final error, nil for
+// success; the probe label comes from ctx (see WithProbe).
+func (cs *ClientService) record(ctx context.Context, method string, err error) prometheus.Labels {
+	labels := prometheus.Labels{
+		"method": method,
+		"probe":  ProbeFromContext(ctx),
+		"code":   codeOf(err).String(),
+	}
+	cs.totalCalls.With(labels).Inc()
+	if err != nil {
+		cs.failureCalls.With(labels).Inc()
+	} else {
+		cs.successCalls.With(labels).Inc()
+	}
+	return labels
+}
 
+// Send calls the Monitoring RPC with message and returns the server's reply.
+func (cs *ClientService) Send(ctx context.Context, message string) (string, error) {
 	req := &monitoringpb.MonitoringClientRequest{
 		ClientRequest: &monitoringpb.Client{
-			Message:     "ping",
+			Message:     message,
 			RequestDate: timestamppb.Now(),
 		},
 	}
+
+	start := time.Now()
 	resp, err := cs.client.Monitoring(ctx, req)
+	labels := cs.record(ctx, methodMonitoring, err)
+	cs.latency.With(labels).Observe(time.Since(start).Seconds())
 	if err != nil {
-		cs.failureCalls.Inc()
 		return "", err
 	}
-	cs.successCalls.Inc()
 	return resp.GetMessage(), nil
 }
 
+func (cs *ClientService) SendPing(ctx context.Context) (string, error) {
+	return cs.Send(ctx, "ping")
+}
+
 func (cs *ClientService) SendWrong(ctx context.Context) error {
-	cs.totalCalls.Inc()
+	_, err := cs.Send(ctx, "wrong")
+	if st, ok := status.FromError(err); ok && st.Code() == codes.InvalidArgument {
+		return nil
+	}
+	return err
+}
 
-	req := &monitoringpb.MonitoringClientRequest{
-		ClientRequest: &monitoringpb.Client{
-			Message:     "wrong",
-			RequestDate: timestamppb.Now(),
-		},
+// PingProbe returns a probe that sends "ping" and expects OK.
+func (cs *ClientService) PingProbe(interval, timeout time.Duration) Probe {
+	return cs.MonitoringProbe("ping", "ping", interval, timeout, codes.OK, nil)
+}
+
+// WrongProbe returns a probe that sends "wrong" and expects InvalidArgument.
+func (cs *ClientService) WrongProbe(interval, timeout time.Duration) Probe {
+	return cs.MonitoringProbe("wrong", "wrong", interval, timeout, codes.InvalidArgument, nil)
+}
+
+// MonitoringProbe returns a probe that sends message through the Monitoring
+// RPC. When pattern is set, it must match the reply message, or the status
+// message if the RPC fails, for the run to count as a success.
+func (cs *ClientService) MonitoringProbe(
+	name, message string,
+	interval, timeout time.Duration,
+	expected codes.Code,
+	pattern *regexp.Regexp,
+) Probe {
+	return NewProbe(name, cs.target, interval, timeout, expected, func(ctx context.Context) error {
+		reply, err := cs.Send(ctx, message)
+		if pattern == nil {
+			return err
+		}
+		text := reply
+		if err != nil {
+			text = status.Convert(err).Message()
+		}
+		if !pattern.MatchString(text) {
+			if err != nil {
+				return fmt.Errorf("%w: %q does not match %q: %w", ErrUnexpectedResponse, text, pattern, err)
+			}
+			return fmt.Errorf("%w: %q does not match %q", ErrUnexpectedResponse, text, pattern)
+		}
+		return err
+	})
+}
+
+// WatchProbe returns a probe that opens a Watch stream and succeeds once the
+// first heartbeat arrives.
+func (cs *ClientService) WatchProbe(name string, interval, timeout time.Duration, expected codes.Code) Probe {
+	return NewProbe(name, cs.target, interval, timeout, expected, func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		return cs.Watch(ctx, watchProbeInterval, func(*monitoringpb.Heartbeat) {
+			cancel()
+		})
+	})
+}
+
+// Watch opens a heartbeat stream and calls onHeartbeat for every message
+// received. It blocks until the stream ends; cancelling ctx ends it cleanly
+// and returns nil, while any other stream error is returned to the caller.
+func (cs *ClientService) Watch(
+	ctx context.Context,
+	interval time.Duration,
+	onHeartbeat func(*monitoringpb.Heartbeat),
+) error {
+	req := &monitoringpb.WatchRequest{
+		Interval: durationpb.New(interval),
 	}
-	_, err := cs.client.Monitoring(ctx, req)
+	stream, err := cs.client.Watch(ctx, req)
 	if err != nil {
-		cs.failureCalls.Inc()
-		if st, ok := status.FromError(err); ok && st.Code() == codes.InvalidArgument {
-			return nil
+		cs.record(ctx, methodWatch, err)
+		return err
+	}
+
+	for {
+		hb, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) || (ctx.Err() != nil && status.Code(err) == codes.Canceled) {
+				cs.record(ctx, methodWatch, nil)
+				return nil
+			}
+			cs.record(ctx, methodWatch, err)
+			return err
 		}
+		if onHeartbeat != nil {
+			onHeartbeat(hb)
+		}
+	}
+}
+
+// PingStream keeps one bidirectional stream open, sending a timestamped ping
+// every interval and recording the latency of each echo. onPong, if set, is
+// called with the timing of every message. It blocks until the stream ends;
+// cancelling ctx ends it cleanly and returns nil.
+func (cs *ClientService) PingStream(
+	ctx context.Context,
+	interval time.Duration,
+	onPong func(PingLatency),
+) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := cs.client.PingStream(streamCtx)
+	if err != nil {
+		cs.record(ctx, methodPingStream, err)
 		return err
 	}
 
-	cs.successCalls.Inc()
-	return nil
+	// Sender: Recv below reports the real stream status, so send errors only
+	// need to stop this goroutine.
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-streamCtx.Done():
+				return
+			case <-ticker.C:
+				req := &monitoringpb.Client{
+					Message:     "ping",
+					RequestDate: timestamppb.Now(),
+				}
+				if err := stream.Send(req); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) || (ctx.Err() != nil && status.Code(err) == codes.Canceled) {
+				cs.record(ctx, methodPingStream, nil)
+				return nil
+			}
+			cs.record(ctx, methodPingStream, err)
+			return err
+		}
+		lat := cs.observePong(resp, time.Now())
+		if onPong != nil {
+			onPong(lat)
+		}
+	}
+}
+
+func (cs *ClientService) observePong(resp *monitoringpb.PingStreamResponse, receivedAt time.Time) PingLatency {
+	requestDate := resp.GetClientRequest().GetRequestDate().AsTime()
+	lat := PingLatency{
+		ClientToServer: resp.GetReceivedAt().AsTime().Sub(requestDate),
+		ServerToClient: receivedAt.Sub(resp.GetSentAt().AsTime()),
+		RoundTrip:      receivedAt.Sub(requestDate),
+	}
+
+	// Clock skew can make a one-way value negative; clamp so it lands in the
+	// lowest bucket instead of dragging the histogram sum below zero.
+	cs.oneWayLatency.WithLabelValues("client_to_server").Observe(max(lat.ClientToServer, 0).Seconds())
+	cs.oneWayLatency.WithLabelValues("server_to_client").Observe(max(lat.ServerToClient, 0).Seconds())
+	cs.roundTrip.Observe(lat.RoundTrip.Seconds())
+	return lat
 }
@@ -5,12 +5,28 @@ import (
 	"context"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"net"
+	"strings"
 	"testing"
+	"time"
+)
+
+// testWatchHeartbeats is how many heartbeats testServer sends before failing the stream.
+const testWatchHeartbeats = 3
+
+// Health service names registered by startTestGRPCServer.
+const (
+	testServingService    = "Monitoring.MonitoringService"
+	testNotServingService = "Draining.Service"
 )
 
 type testServer struct {
@@ -45,6 +61,43 @@ func (s *testServer) Monitoring(
 	}, nil
 }
 
+func (s *testServer) Watch(
+	req *monitoringpb.WatchRequest,
+	stream grpc.ServerStreamingServer[monitoringpb.Heartbeat],
+) error {
+	for seq := uint64(1); seq <= testWatchHeartbeats; seq++ {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-time.After(req.GetInterval().AsDuration()):
+		}
+		if err := stream.Send(&monitoringpb.Heartbeat{Sequence: seq, SentAt: timestamppb.Now()}); err != nil {
+			return err
+		}
+	}
+	return status.Error(codes.Unavailable, "server going away")
+}
+
+func (s *testServer) PingStream(
+	stream grpc.BidiStreamingServer[monitoringpb.Client, monitoringpb.PingStreamResponse],
+) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		receivedAt := timestamppb.Now()
+		resp := &monitoringpb.PingStreamResponse{
+			ClientRequest: req,
+			ReceivedAt:    receivedAt,
+			SentAt:        timestamppb.Now(),
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
 func startTestGRPCServer(t *testing.T) (addr string, cleanup func()) {
 	t.Helper()
 
@@ -56,6 +109,11 @@ func startTestGRPCServer(t *testing.T) (addr string, cleanup func()) {
 	server := grpc.NewServer()
 	monitoringpb.RegisterMonitoringServiceServer(server, &testServer{})
 
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus(testServingService, healthpb.HealthCheckResponse_SERVING)
+	healthSrv.SetServingStatus(testNotServingService, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(server, healthSrv)
+
 	go func() {
 		_ = server.Serve(lis)
 	}()
@@ -70,22 +128,12 @@ func TestClientService_SendPingAndSendWrong(t *testing.T) {
 	addr, cleanup := startTestGRPCServer(t)
 	defer cleanup()
 
-	var registered []prometheus.Collector
-
-	unregisterAll := func() {
-		for _, c := range registered {
-			prometheus.Unregister(c)
-		}
-	}
-
-	defer unregisterAll()
-
 	dialOpts := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithChainUnaryInterceptor(),
 	}
 
-	clientSvc, err := NewClientService(addr, dialOpts...)
+	clientSvc, err := NewClientService(addr, WithDialOptions(dialOpts...), WithRegisterer(prometheus.NewRegistry()))
 	if err != nil {
 		t.Fatalf("NewClientService(%q) error: %v", addr, err)
 	}
@@ -95,8 +143,6 @@ func TestClientService_SendPingAndSendWrong(t *testing.T) {
 		}
 	}()
 
-	registered = append(registered, clientSvc.totalCalls, clientSvc.successCalls, clientSvc.failureCalls)
-
 	t.Run("SendPing_Success", func(t *testing.T) {
 		msg, err := clientSvc.SendPing(context.Background())
 		if err != nil {
@@ -116,6 +162,65 @@ func TestClientService_SendPingAndSendWrong(t *testing.T) {
 			t.Fatalf("SendWrong returned unexpected error: %v", err)
 		}
 	})
+
+	t.Run("Watch_CancelledByClient", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var got []uint64
+		err := clientSvc.Watch(ctx, 10*time.Millisecond, func(hb *monitoringpb.Heartbeat) {
+			got = append(got, hb.GetSequence())
+			if len(got) == 2 {
+				cancel()
+			}
+		})
+		if err != nil {
+			t.Fatalf("Watch returned error after cancel: %v", err)
+		}
+		if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+			t.Errorf("unexpected heartbeat sequences: %v", got)
+		}
+	})
+
+	t.Run("PingStream_RecordsLatency", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var pongs []PingLatency
+		err := clientSvc.PingStream(ctx, 10*time.Millisecond, func(lat PingLatency) {
+			pongs = append(pongs, lat)
+			if len(pongs) == 3 {
+				cancel()
+			}
+		})
+		if err != nil {
+			t.Fatalf("PingStream returned error after cancel: %v", err)
+		}
+		for i, lat := range pongs {
+			if lat.RoundTrip <= 0 {
+				t.Errorf("pong #%d: expected positive round trip, got %s", i, lat.RoundTrip)
+			}
+			if lat.ClientToServer > lat.RoundTrip || lat.ServerToClient > lat.RoundTrip {
+				t.Errorf("pong #%d: one-way latency exceeds round trip: %+v", i, lat)
+			}
+		}
+		if got := testutil.CollectAndCount(clientSvc.oneWayLatency); got != 2 {
+			t.Errorf("expected one-way histograms for 2 directions, got %d", got)
+		}
+	})
+
+	t.Run("Watch_MidStreamFailure", func(t *testing.T) {
+		received := 0
+		err := clientSvc.Watch(context.Background(), 10*time.Millisecond, func(*monitoringpb.Heartbeat) {
+			received++
+		})
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("expected Unavailable from Watch, got %v", err)
+		}
+		if received != testWatchHeartbeats {
+			t.Errorf("expected %d heartbeats before failure, got %d", testWatchHeartbeats, received)
+		}
+	})
 }
 
 func endsWith(s, suffix string) bool {
@@ -125,6 +230,114 @@ func endsWith(s, suffix string) bool {
 	return s[len(s)-len(suffix):] == suffix
 }
 
+func TestClientService_MultipleTargets(t *testing.T) {
+	addrA, cleanupA := startTestGRPCServer(t)
+	defer cleanupA()
+	addrB, cleanupB := startTestGRPCServer(t)
+	defer cleanupB()
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+
+	// Both services share one registry, and so the same metric vectors.
+	reg := prometheus.NewRegistry()
+	svcA, err := NewClientService(addrA, WithDialOptions(dialOpts...), WithRegisterer(reg))
+	if err != nil {
+		t.Fatalf("NewClientService(%q) error: %v", addrA, err)
+	}
+	defer svcA.Close()
+	svcB, err := NewClientService(addrB, WithDialOptions(dialOpts...), WithRegisterer(reg))
+	if err != nil {
+		t.Fatalf("NewClientService(%q) error: %v", addrB, err)
+	}
+	defer svcB.Close()
+
+	if _, err := svcA.SendPing(context.Background()); err != nil {
+		t.Fatalf("SendPing to %s returned error: %v", addrA, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := svcB.SendWrong(context.Background()); err != nil {
+			t.Fatalf("SendWrong to %s returned error: %v", addrB, err)
+		}
+	}
+
+	okLabels := prometheus.Labels{"method": "Monitoring", "probe": "", "code": "OK"}
+	invalidLabels := prometheus.Labels{"method": "Monitoring", "probe": "", "code": "InvalidArgument"}
+
+	if got := testutil.ToFloat64(svcA.successCalls.With(okLabels)); got != 1 {
+		t.Errorf("expected 1 success for %s, got %v", addrA, got)
+	}
+	if got := testutil.ToFloat64(svcA.failureCalls.With(invalidLabels)); got != 0 {
+		t.Errorf("expected 0 failures for %s, got %v", addrA, got)
+	}
+	if got := testutil.ToFloat64(svcB.failureCalls.With(invalidLabels)); got != 2 {
+		t.Errorf("expected 2 InvalidArgument failures for %s, got %v", addrB, got)
+	}
+	if got := testutil.ToFloat64(svcB.totalCalls.With(invalidLabels)); got != 2 {
+		t.Errorf("expected 2 total requests for %s, got %v", addrB, got)
+	}
+	if got := histogramCount(t, svcB.latency.With(invalidLabels)); got != 2 {
+		t.Errorf("expected 2 latency observations for %s, got %d", addrB, got)
+	}
+	if probe := svcB.PingProbe(time.Second, time.Second); probe.Target() != addrB {
+		t.Errorf("expected probe target %q, got %q", addrB, probe.Target())
+	}
+}
+
+func histogramCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+
+	h, ok := o.(prometheus.Histogram)
+	if !ok {
+		t.Fatalf("expected a histogram, got %T", o)
+	}
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatalf("failed to read histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestClientService_MetricOptions(t *testing.T) {
+	addr, cleanup := startTestGRPCServer(t)
+	defer cleanup()
+
+	dialOpts := WithDialOptions(grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	// The same target in two registries must not clash.
+	regA, regB := prometheus.NewRegistry(), prometheus.NewRegistry()
+	svcA, err := NewClientService(addr, dialOpts, WithRegisterer(regA),
+		WithNamespace("myapp"), WithSubsystem("synthetic"),
+		WithConstLabels(prometheus.Labels{"env": "test"}))
+	if err != nil {
+		t.Fatalf("NewClientService(%q) error: %v", addr, err)
+	}
+	defer svcA.Close()
+	svcB, err := NewClientService(addr, dialOpts, WithRegisterer(regB))
+	if err != nil {
+		t.Fatalf("NewClientService(%q) with a second registry error: %v", addr, err)
+	}
+	defer svcB.Close()
+
+	if _, err := svcA.SendPing(context.Background()); err != nil {
+		t.Fatalf("SendPing returned error: %v", err)
+	}
+
+	const want = `
+# HELP myapp_synthetic_grpc_client_success_requests Number of successful gRPC requests
+# TYPE myapp_synthetic_grpc_client_success_requests counter
+myapp_synthetic_grpc_client_success_requests{code="OK",env="test",method="Monitoring",probe="",target="` + "%s" + `"} 1
+`
+	expected := strings.NewReader(fmt.Sprintf(want, addr))
+	if err := testutil.GatherAndCompare(regA, expected, "myapp_synthetic_grpc_client_success_requests"); err != nil {
+		t.Errorf("unexpected metrics in injected registry: %v", err)
+	}
+	if n, err := testutil.GatherAndCount(regB, "grpc_client_success_requests"); err != nil || n != 0 {
+		t.Errorf("expected no samples in the second registry, got %d (%v)", n, err)
+	}
+}
+
 func TestClientService_BadAddress(t *testing.T) {
 	badAddr := "127.0.0.1:65535"
 
@@ -132,7 +345,7 @@ func TestClientService_BadAddress(t *testing.T) {
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 	}
 
-	clientSvc, err := NewClientService(badAddr, dialOpts...)
+	clientSvc, err := NewClientService(badAddr, WithDialOptions(dialOpts...), WithRegisterer(prometheus.NewRegistry()))
 	if err != nil {
 		t.Fatalf("NewClientService(%q) returned unexpected error: %v", badAddr, err)
 	}
@@ -142,6 +355,10 @@ func TestClientService_BadAddress(t *testing.T) {
 	if pingErr == nil {
 		t.Fatalf("SendPing to bad address %q succeeded unexpectedly", badAddr)
 	}
+	unavailable := prometheus.Labels{"method": "Monitoring", "probe": "", "code": "Unavailable"}
+	if got := testutil.ToFloat64(clientSvc.failureCalls.With(unavailable)); got != 1 {
+		t.Errorf("expected the outage to be counted as 1 Unavailable failure, got %v", got)
+	}
 	errMsg := pingErr.Error()
 	if !contains(errMsg, "connection") && !contains(errMsg, "refused") && !contains(errMsg, "connect") {
 		t.Errorf("unexpected error for SendPing to bad address: %v", pingErr)
@@ -152,7 +369,7 @@ func TestClientService_InvalidArgs(t *testing.T) {
 	addr, cleanup := startTestGRPCServer(t)
 	defer cleanup()
 
-	_, err := NewClientService(addr)
+	_, err := NewClientService(addr, WithRegisterer(prometheus.NewRegistry()))
 	if err == nil {
 		t.Fatal("expected error when no dial options provided, got nil")
 	}
@@ -0,0 +1,190 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"client/internal/config"
+)
+
+// exportSpan sends one span through exp and flushes it.
+func exportSpan(t *testing.T, exp sdktrace.SpanExporter) {
+	t.Helper()
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	_, span := tp.Tracer("test").Start(context.Background(), "test")
+	span.End()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tp.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to export span: %v", err)
+	}
+}
+
+func TestNewOTLPExporter_HTTP(t *testing.T) {
+	type request struct {
+		path, authorization, encoding string
+	}
+	requests := make(chan request, 1)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- request{r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Content-Encoding")}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	exp, check, err := NewOTLPExporter(context.Background(), &config.Config{
+		OTLPCollectorEndpoint: srv.URL,
+		OTLPProtocol:          config.OTLPProtocolHTTP,
+		OTLPCAFile:            caFile,
+		OTLPHeaders:           config.Headers{"authorization=Bearer%20s3cret"},
+		OTLPCompression:       config.OTLPCompressionGzip,
+		OTLPTimeout:           5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter returned error: %v", err)
+	}
+	if check != nil {
+		t.Error("expected no connection check for OTLP/HTTP")
+	}
+	exportSpan(t, exp)
+
+	got := <-requests
+	if want := (request{"/v1/traces", "Bearer s3cret", "gzip"}); got != want {
+		t.Errorf("unexpected export request: got %+v, want %+v", got, want)
+	}
+}
+
+type traceCollector struct {
+	coltracepb.UnimplementedTraceServiceServer
+	authorization chan []string
+}
+
+func (c *traceCollector) Export(ctx context.Context, _ *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.authorization <- md.Get("authorization")
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func TestNewOTLPExporter_GRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	collector := &traceCollector{authorization: make(chan []string, 1)}
+	srv := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(srv, collector)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	exp, check, err := NewOTLPExporter(context.Background(), &config.Config{
+		OTLPCollectorEndpoint: "http://" + lis.Addr().String(),
+		OTLPHeaders:           config.Headers{"authorization=Bearer s3cret"},
+		OTLPCompression:       config.OTLPCompressionGzip,
+	})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter returned error: %v", err)
+	}
+	exportSpan(t, exp)
+
+	if got := <-collector.authorization; len(got) != 1 || got[0] != "Bearer s3cret" {
+		t.Errorf("unexpected authorization metadata: %q", got)
+	}
+	if err := check(context.Background()); err != nil {
+		t.Errorf("expected the connection check to pass, got: %v", err)
+	}
+}
+
+func TestNewOTLPExporter_Invalid(t *testing.T) {
+	_, _, err := NewOTLPExporter(context.Background(), &config.Config{
+		OTLPCollectorEndpoint: "collector:4317",
+		OTLPCAFile:            "/does/not/exist",
+	})
+	if err == nil {
+		t.Fatal("expected a missing collector CA file to fail")
+	}
+}
+
+func TestNewExporter_None(t *testing.T) {
+	exp, check, err := NewExporter(context.Background(), &config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp != nil || check != nil {
+		t.Errorf("without a collector endpoint got exporter %v; want none", exp)
+	}
+}
+
+func TestNewExporter_StdoutFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	exp, check, err := NewExporter(context.Background(), &config.Config{
+		TracesExporter: config.TracesExporterStdout,
+		TracesFile:     path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check != nil {
+		t.Error("stdout exporter has a health check")
+	}
+	before := testutil.ToFloat64(exportedSpans.WithLabelValues("stdout", "success"))
+	exportSpan(t, exp)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"test"`) {
+		t.Errorf("traces file does not contain the span:\n%s", data)
+	}
+	if got := testutil.ToFloat64(exportedSpans.WithLabelValues("stdout", "success")) - before; got != 1 {
+		t.Errorf("exported spans = %v, want 1", got)
+	}
+}
+
+func TestNewExporter_Failure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "rejected", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	exp, check, err := NewExporter(context.Background(), &config.Config{
+		OTLPCollectorEndpoint: srv.URL,
+		OTLPProtocol:          config.OTLPProtocolHTTP,
+		OTLPTimeout:           5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := check(context.Background()); err != nil {
+		t.Errorf("check before any export: %v", err)
+	}
+	before := testutil.ToFloat64(exportFailures.WithLabelValues("otlp_http"))
+	exportSpan(t, exp)
+
+	if got := testutil.ToFloat64(exportFailures.WithLabelValues("otlp_http")) - before; got != 1 {
+		t.Errorf("export failures = %v, want 1", got)
+	}
+	if err := check(context.Background()); err == nil {
+		t.Error("check passed after a failed export")
+	}
+}
@@ -0,0 +1,89 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+
+	"client/internal/config"
+)
+
+func TestNewResource(t *testing.T) {
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "deployment.environment=from-env,team=observability")
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want map[attribute.Key]string
+	}{
+		{
+			name: "environment variable",
+			cfg:  config.Config{ServiceInstanceID: "replica-1"},
+			want: map[attribute.Key]string{
+				"service.name":           "grpc-client",
+				"service.instance.id":    "replica-1",
+				"deployment.environment": "from-env",
+				"team":                   "observability",
+			},
+		},
+		{
+			name: "settings win",
+			cfg:  config.Config{DeploymentEnvironment: "staging"},
+			want: map[attribute.Key]string{
+				"deployment.environment": "staging",
+				"team":                   "observability",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := NewResource(context.Background(), &tc.cfg, "grpc-client")
+			if err != nil {
+				t.Fatal(err)
+			}
+			set := res.Set()
+			for key, want := range tc.want {
+				if got, ok := set.Value(key); !ok || got.Emit() != want {
+					t.Errorf("%s = %q, want %q", key, got.Emit(), want)
+				}
+			}
+			for _, key := range []attribute.Key{"service.version", "service.instance.id", "host.name"} {
+				if v, ok := set.Value(key); !ok || v.Emit() == "" {
+					t.Errorf("%s is missing", key)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterTargetInfo(t *testing.T) {
+	res, err := NewResource(context.Background(), &config.Config{DeploymentEnvironment: "staging"}, "grpc-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	RegisterTargetInfo(reg, res)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetName() != "target_info" {
+		t.Fatalf("got metric families %v, want target_info", families)
+	}
+	metric := families[0].GetMetric()[0]
+	if got := metric.GetGauge().GetValue(); got != 1 {
+		t.Errorf("target_info = %v, want 1", got)
+	}
+	labels := make(map[string]string)
+	for _, l := range metric.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	for name, want := range map[string]string{"service_name": "grpc-client", "deployment_environment": "staging"} {
+		if labels[name] != want {
+			t.Errorf("label %s = %q, want %q", name, labels[name], want)
+		}
+	}
+}
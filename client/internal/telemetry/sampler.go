@@ -0,0 +1,230 @@
+// Package telemetry builds the OpenTelemetry pipelines: the span and
+// metric exporters, the resource that describes the process, and a sampler
+// that can be reconfigured while the process runs.
+package telemetry
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"client/internal/config"
+)
+
+// Sampler is the sampler chosen by TracesSampler, with the per-method
+// TracesSamplerOverrides. Configure may be called at any time; spans
+// started afterwards follow the new settings.
+//
+// Under the errors override, calls that are not sampled are still
+// recorded, and the span processor returned by NewSpanProcessor exports
+// those that fail.
+type Sampler struct {
+	current atomic.Pointer[sdktrace.Sampler]
+
+	mu sync.Mutex
+	// rules are the overrides last configured, checked against the
+	// methods set by SetMethods.
+	rules   methodRules
+	methods []string
+}
+
+// NewSampler returns a Sampler that follows the OpenTelemetry default,
+// parentbased_always_on, until Configure is called.
+func NewSampler() *Sampler {
+	s := &Sampler{}
+	sampler := sdktrace.ParentBased(sdktrace.AlwaysSample())
+	s.current.Store(&sampler)
+	return s
+}
+
+// Configure switches to the sampler settings of cfg.
+func (s *Sampler) Configure(cfg *config.Config) error {
+	rules, err := parseOverrides(cfg.TracesSamplerOverrides)
+	if err != nil {
+		return err
+	}
+
+	name := cmp.Or(cfg.TracesSampler, config.SamplerParentBasedTraceIDRatio)
+	var root sdktrace.Sampler
+	switch strings.TrimPrefix(name, "parentbased_") {
+	case config.SamplerAlwaysOn:
+		root = sdktrace.AlwaysSample()
+	case config.SamplerAlwaysOff:
+		root = sdktrace.NeverSample()
+	case config.SamplerTraceIDRatio:
+		root = sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio)
+	default:
+		return fmt.Errorf("telemetry: unknown sampler %q", name)
+	}
+
+	// Overrides replace the decision for new traces only, so that a
+	// parent-based sampler still keeps traces whole; errors applies to
+	// every span.
+	var sampler sdktrace.Sampler = &overrideSampler{root: root, rules: rules}
+	if strings.HasPrefix(name, "parentbased_") {
+		sampler = sdktrace.ParentBased(sampler)
+	}
+	if rules.keepErrors() {
+		sampler = &errorSampler{next: sampler, rules: rules}
+	}
+	s.current.Store(&sampler)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+	s.warnUnmatchedLocked()
+	return nil
+}
+
+// SetMethods sets the full names (/package.Service/Method) of the methods
+// the process serves or calls. Overrides that match none of them are
+// logged, now and whenever Configure is called again.
+func (s *Sampler) SetMethods(methods []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods = methods
+	s.warnUnmatchedLocked()
+}
+
+// warnUnmatchedLocked logs the overrides that match no method. s.mu must
+// be held.
+func (s *Sampler) warnUnmatchedLocked() {
+	for _, method := range s.rules.unmatched(s.methods) {
+		log.Printf("[TRACES] sampler override %s matches no gRPC method of this process; method names are case-sensitive", method)
+	}
+}
+
+func (s *Sampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return (*s.current.Load()).ShouldSample(p)
+}
+
+func (s *Sampler) Description() string {
+	return fmt.Sprintf("Sampler{%s}", (*s.current.Load()).Description())
+}
+
+// methodRule is a parsed sampler override: either sampler decides, or
+// keepErrors records the calls that the configured sampler drops.
+type methodRule struct {
+	sampler    sdktrace.Sampler
+	keepErrors bool
+}
+
+// methodRules are the sampler overrides, keyed by /package.Service/Method
+// or by /package.Service for a /package.Service/* entry.
+type methodRules map[string]methodRule
+
+func parseOverrides(entries []string) (methodRules, error) {
+	rules := make(methodRules, len(entries))
+	for _, entry := range entries {
+		o, err := config.ParseSamplerOverride(entry)
+		if err != nil {
+			return nil, fmt.Errorf("telemetry: %w", err)
+		}
+		var rule methodRule
+		switch o.Sampler {
+		case config.SamplerAlwaysOn:
+			rule.sampler = sdktrace.AlwaysSample()
+		case config.SamplerAlwaysOff:
+			rule.sampler = sdktrace.NeverSample()
+		case config.SamplerTraceIDRatio:
+			rule.sampler = sdktrace.TraceIDRatioBased(o.Ratio)
+		case config.SamplerErrors:
+			rule.keepErrors = true
+		}
+		rules[strings.TrimSuffix(o.Method, "/*")] = rule
+	}
+	return rules, nil
+}
+
+// lookup returns the rule for a span. otelgrpc names spans after the full
+// method, without its leading slash.
+func (r methodRules) lookup(spanName string) (methodRule, bool) {
+	method := "/" + spanName
+	if rule, ok := r[method]; ok {
+		return rule, true
+	}
+	service, _, _ := strings.Cut(method[1:], "/")
+	rule, ok := r["/"+service]
+	return rule, ok
+}
+
+// unmatched returns the overrides, sorted, that match none of methods. It
+// returns none if methods is empty.
+func (r methodRules) unmatched(methods []string) []string {
+	if len(methods) == 0 {
+		return nil
+	}
+	var keys []string
+	for key := range r {
+		matches := func(method string) bool {
+			return method == key || strings.HasPrefix(method, key+"/")
+		}
+		if slices.ContainsFunc(methods, matches) {
+			continue
+		}
+		// Service keys are stored without their /*.
+		if strings.Count(key, "/") == 1 {
+			key += "/*"
+		}
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (r methodRules) keepErrors() bool {
+	for _, rule := range r {
+		if rule.keepErrors {
+			return true
+		}
+	}
+	return false
+}
+
+// overrideSampler samples with the sampler of the matching override, or
+// with root.
+type overrideSampler struct {
+	root  sdktrace.Sampler
+	rules methodRules
+}
+
+func (s *overrideSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if rule, ok := s.rules.lookup(p.Name); ok && rule.sampler != nil {
+		return rule.sampler.ShouldSample(p)
+	}
+	return s.root.ShouldSample(p)
+}
+
+func (s *overrideSampler) Description() string {
+	if len(s.rules) == 0 {
+		return s.root.Description()
+	}
+	return fmt.Sprintf("%s with %d method overrides", s.root.Description(), len(s.rules))
+}
+
+// errorSampler records the spans of errors overrides that next drops, so
+// that they can be exported if the call fails.
+type errorSampler struct {
+	next  sdktrace.Sampler
+	rules methodRules
+}
+
+func (s *errorSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	res := s.next.ShouldSample(p)
+	if res.Decision == sdktrace.Drop {
+		if rule, _ := s.rules.lookup(p.Name); rule.keepErrors {
+			res.Decision = sdktrace.RecordOnly
+		}
+	}
+	return res
+}
+
+func (s *errorSampler) Description() string {
+	return fmt.Sprintf("KeepErrors{%s}", s.next.Description())
+}
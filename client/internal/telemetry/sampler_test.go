@@ -0,0 +1,200 @@
+package telemetry
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"client/internal/config"
+	monitoringpb "client/internal/pb/monitoring"
+)
+
+// TraceIDRatioBased compares the last eight bytes of the trace ID with the
+// ratio; at their maximum, any ratio below 1 drops the trace.
+var unluckyTraceID = trace.TraceID{0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// withParent returns a context holding a remote parent span.
+func withParent(sampled bool) context.Context {
+	var flags trace.TraceFlags
+	if sampled {
+		flags = trace.FlagsSampled
+	}
+	return trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    unluckyTraceID,
+		SpanID:     trace.SpanID{1},
+		TraceFlags: flags,
+		Remote:     true,
+	}))
+}
+
+func TestSampler_Configure(t *testing.T) {
+	// otelgrpc names spans after the full method, without its leading
+	// slash.
+	monitoring := strings.TrimPrefix(monitoringpb.MonitoringService_Monitoring_FullMethodName, "/")
+	watch := strings.TrimPrefix(monitoringpb.MonitoringService_Watch_FullMethodName, "/")
+	service := "/" + monitoringpb.MonitoringService_ServiceDesc.ServiceName + "/*"
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		// parent is nil for a new trace.
+		parent *bool
+		span   string
+		want   sdktrace.SamplingDecision
+	}{
+		{name: "default ratio 1", cfg: config.Config{TraceSampleRatio: 1}, span: "test", want: sdktrace.RecordAndSample},
+		{name: "default ratio 0.5", cfg: config.Config{TraceSampleRatio: 0.5}, span: "test", want: sdktrace.Drop},
+		{name: "always_on", cfg: config.Config{TracesSampler: config.SamplerAlwaysOn}, span: "test", want: sdktrace.RecordAndSample},
+		{name: "always_off", cfg: config.Config{TracesSampler: config.SamplerAlwaysOff}, span: "test", want: sdktrace.Drop},
+		{name: "always_off ignores the parent", cfg: config.Config{TracesSampler: config.SamplerAlwaysOff}, parent: ptr(true), span: "test", want: sdktrace.Drop},
+		{name: "traceidratio ignores the parent", cfg: config.Config{TracesSampler: config.SamplerTraceIDRatio}, parent: ptr(true), span: "test", want: sdktrace.Drop},
+		{name: "parentbased_always_off sampled parent", cfg: config.Config{TracesSampler: config.SamplerParentBasedAlwaysOff}, parent: ptr(true), span: "test", want: sdktrace.RecordAndSample},
+		{name: "parentbased_always_on dropped parent", cfg: config.Config{TracesSampler: config.SamplerParentBasedAlwaysOn}, parent: ptr(false), span: "test", want: sdktrace.Drop},
+		{
+			name: "override",
+			cfg:  config.Config{TracesSampler: config.SamplerAlwaysOff, TracesSamplerOverrides: []string{"/" + monitoring + "=always_on"}},
+			span: monitoring, want: sdktrace.RecordAndSample,
+		},
+		{
+			name: "override of another method",
+			cfg:  config.Config{TracesSampler: config.SamplerAlwaysOff, TracesSamplerOverrides: []string{"/" + monitoring + "=always_on"}},
+			span: watch, want: sdktrace.Drop,
+		},
+		{
+			name: "method wins over service",
+			cfg: config.Config{TracesSampler: config.SamplerAlwaysOn, TracesSamplerOverrides: []string{
+				service + "=always_off", "/" + monitoring + "=1",
+			}},
+			span: monitoring, want: sdktrace.RecordAndSample,
+		},
+		{
+			name: "service override",
+			cfg:  config.Config{TracesSampler: config.SamplerAlwaysOn, TracesSamplerOverrides: []string{service + "=always_off"}},
+			span: watch, want: sdktrace.Drop,
+		},
+		{
+			name:   "parentbased override follows the parent",
+			cfg:    config.Config{TracesSampler: config.SamplerParentBasedAlwaysOn, TracesSamplerOverrides: []string{"/" + monitoring + "=always_off"}},
+			parent: ptr(true), span: monitoring, want: sdktrace.RecordAndSample,
+		},
+		{
+			name:   "errors records dropped calls",
+			cfg:    config.Config{TracesSampler: config.SamplerParentBasedAlwaysOn, TracesSamplerOverrides: []string{"/" + monitoring + "=errors"}},
+			parent: ptr(false), span: monitoring, want: sdktrace.RecordOnly,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSampler()
+			if err := s.Configure(&tc.cfg); err != nil {
+				t.Fatal(err)
+			}
+			params := sdktrace.SamplingParameters{ParentContext: context.Background(), TraceID: unluckyTraceID, Name: tc.span}
+			if tc.parent != nil {
+				params.ParentContext = withParent(*tc.parent)
+			}
+			if got := s.ShouldSample(params).Decision; got != tc.want {
+				t.Errorf("got decision %v, want %v (%s)", got, tc.want, s.Description())
+			}
+		})
+	}
+}
+
+func TestSampler_ConfigureRatio(t *testing.T) {
+	s := NewSampler()
+	params := sdktrace.SamplingParameters{ParentContext: context.Background(), TraceID: unluckyTraceID, Name: "test"}
+
+	tests := []struct {
+		ratio float64
+		want  sdktrace.SamplingDecision
+	}{
+		{ratio: 1, want: sdktrace.RecordAndSample},
+		{ratio: 0.5, want: sdktrace.Drop},
+		{ratio: 0, want: sdktrace.Drop},
+		{ratio: 1, want: sdktrace.RecordAndSample},
+	}
+	for _, tc := range tests {
+		if err := s.Configure(&config.Config{TraceSampleRatio: tc.ratio}); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.ShouldSample(params).Decision; got != tc.want {
+			t.Errorf("ratio %g: got decision %v, want %v", tc.ratio, got, tc.want)
+		}
+	}
+}
+
+func TestNewSpanProcessor_KeepsErrors(t *testing.T) {
+	s := NewSampler()
+	if err := s.Configure(&config.Config{
+		TracesSampler:          config.SamplerAlwaysOff,
+		TracesSamplerOverrides: []string{monitoringpb.MonitoringService_Monitoring_FullMethodName + "=errors"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(s), sdktrace.WithSpanProcessor(NewSpanProcessor(exp)))
+	tracer := tp.Tracer("test")
+
+	end := func(method string, fail func(trace.Span)) {
+		_, span := tracer.Start(context.Background(), strings.TrimPrefix(method, "/"))
+		if fail != nil {
+			fail(span)
+		}
+		span.End()
+	}
+	end(monitoringpb.MonitoringService_Monitoring_FullMethodName, nil)
+	end(monitoringpb.MonitoringService_Monitoring_FullMethodName, func(span trace.Span) { span.SetStatus(codes.Error, "internal") })
+	end(monitoringpb.MonitoringService_Monitoring_FullMethodName, func(span trace.Span) {
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(3)) // InvalidArgument
+	})
+	end(monitoringpb.MonitoringService_Watch_FullMethodName, func(span trace.Span) { span.SetStatus(codes.Error, "internal") })
+
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exp.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want the 2 failed Monitoring calls", len(spans))
+	}
+	for _, span := range spans {
+		if !span.SpanContext.IsSampled() {
+			t.Errorf("exported span %s is not marked as sampled", span.Name)
+		}
+	}
+}
+
+func TestMethodRules_Unmatched(t *testing.T) {
+	rules, err := parseOverrides([]string{
+		monitoringpb.MonitoringService_Monitoring_FullMethodName + "=errors",
+		"/grpc.health.v1.Health/*=always_off",
+		// The proto package is Monitoring.
+		"/monitoring.MonitoringService/Monitoring=errors",
+		"/Monitoring.Other/*=always_on",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods := []string{
+		monitoringpb.MonitoringService_Monitoring_FullMethodName,
+		monitoringpb.MonitoringService_Watch_FullMethodName,
+		healthpb.Health_Check_FullMethodName,
+	}
+
+	want := []string{"/Monitoring.Other/*", "/monitoring.MonitoringService/Monitoring"}
+	if got := rules.unmatched(methods); !slices.Equal(got, want) {
+		t.Errorf("got unmatched overrides %v, want %v", got, want)
+	}
+	if got := rules.unmatched(nil); got != nil {
+		t.Errorf("got unmatched overrides %v before the methods are known, want none", got)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,160 @@
+// Command certgen writes the development PKI described in certs/README.md:
+// a CA, a server and a client key pair, and the matching openssl ext files.
+//
+//	cd server && go run ./cmd/certgen -out ../certs
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"server/internal/pki"
+)
+
+// Output file names, matching the TLS_* defaults of the server and client.
+const (
+	caCertFile     = "ca.crt.pem"
+	caKeyFile      = "ca.key.pem"
+	serverCertFile = "server.crt.pem"
+	serverKeyFile  = "server.key.pem"
+	serverExtFile  = "server_ext.cnf"
+	clientCertFile = "client.crt.pem"
+	clientKeyFile  = "client.key.pem"
+	clientExtFile  = "client_ext.cnf"
+)
+
+const day = 24 * time.Hour
+
+func main() {
+	out := flag.String("out", "certs", "directory to write the files to")
+	keyType := flag.String("key-type", pki.RSA, "key algorithm: rsa, ecdsa or ed25519")
+	rsaBits := flag.Int("rsa-bits", 4096, "RSA key size")
+	caDays := flag.Int("ca-days", 3650, "CA validity in days")
+	days := flag.Int("days", 365, "server and client certificate validity in days")
+	serverCN := flag.String("server-cn", "localhost", "server certificate common name")
+	serverSANs := flag.String("server-san", "localhost,server,127.0.0.1",
+		"comma-separated server SANs: DNS names, IP addresses or URIs")
+	clientCN := flag.String("client-cn", "Test Client", "client certificate common name")
+	clientSANs := flag.String("client-san", "",
+		"comma-separated client SANs, such as a SPIFFE ID")
+	force := flag.Bool("force", false, "overwrite existing files")
+	flag.Parse()
+
+	if *caDays <= 0 || *days <= 0 {
+		log.Fatal("[CERTGEN] -ca-days and -days must be positive")
+	}
+
+	server := pki.Template{
+		Subject:     pkix.Name{Organization: []string{"MyOrg"}, OrganizationalUnit: []string{"Server"}, CommonName: *serverCN},
+		SANs:        splitList(*serverSANs),
+		Validity:    time.Duration(*days) * day,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	client := pki.Template{
+		Subject:     pkix.Name{Organization: []string{"MyOrg"}, OrganizationalUnit: []string{"Client"}, CommonName: *clientCN},
+		SANs:        splitList(*clientSANs),
+		Validity:    time.Duration(*days) * day,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if err := run(*out, *keyType, *rsaBits, time.Duration(*caDays)*day, server, client, *force); err != nil {
+		log.Fatalf("[CERTGEN] %v", err)
+	}
+}
+
+// run generates the CA, server and client material and writes it to out.
+func run(out, keyType string, rsaBits int, caValidity time.Duration, server, client pki.Template, force bool) error {
+	if !force {
+		for _, name := range []string{
+			caCertFile, caKeyFile,
+			serverCertFile, serverKeyFile, serverExtFile,
+			clientCertFile, clientKeyFile, clientExtFile,
+		} {
+			if _, err := os.Stat(filepath.Join(out, name)); err == nil {
+				return fmt.Errorf("%s already exists; use -force to overwrite", filepath.Join(out, name))
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+
+	caKey, err := pki.GenerateKey(keyType, rsaBits)
+	if err != nil {
+		return err
+	}
+	ca, err := pki.NewCA(pkix.Name{
+		Organization:       []string{"MyOrg"},
+		OrganizationalUnit: []string{"TestCA"},
+		CommonName:         "Test Root CA",
+	}, caKey, caValidity)
+	if err != nil {
+		return err
+	}
+	if err := writeKeyPair(out, caCertFile, caKeyFile, ca.Cert, caKey); err != nil {
+		return err
+	}
+
+	for _, leaf := range []struct {
+		tmpl                   pki.Template
+		certFile, keyFile, ext string
+	}{
+		{server, serverCertFile, serverKeyFile, serverExtFile},
+		{client, clientCertFile, clientKeyFile, clientExtFile},
+	} {
+		key, err := pki.GenerateKey(keyType, rsaBits)
+		if err != nil {
+			return err
+		}
+		cert, err := ca.Issue(leaf.tmpl, key)
+		if err != nil {
+			return err
+		}
+		if err := writeKeyPair(out, leaf.certFile, leaf.keyFile, cert, key); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(out, leaf.ext), []byte(pki.ExtFile(leaf.tmpl, key)), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeKeyPair writes cert and key as PEM; the key is readable by the owner only.
+func writeKeyPair(dir, certFile, keyFile string, cert *x509.Certificate, key crypto.Signer) error {
+	keyPEM, err := pki.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, keyFile), keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, certFile), pki.EncodeCertificate(cert), 0o644); err != nil {
+		return err
+	}
+	log.Printf("[CERTGEN] wrote %s (%s, expires %s)", filepath.Join(dir, certFile),
+		cert.Subject, cert.NotAfter.Format(time.DateOnly))
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -3,6 +3,10 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 	"log"
 	"net"
@@ -16,36 +20,83 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 
 	grpcprometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 
+	"server/internal/authz"
 	"server/internal/config"
+	serverhealth "server/internal/health"
 	monitoringpb "server/internal/pb/monitoring"
 	"server/internal/service"
+	"server/internal/telemetry"
 )
 
 func main() {
-	cfg := config.LoadConfig()
+	cfg, printConfig, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("[CONFIG] %v", err)
+		}
+		return
+	}
+	for _, path := range cfg.LegacyTLSFiles() {
+		log.Printf("[TLS] using %s, the default before it was renamed to %s.pem; rename it or set its TLS_*_FILE setting", path, path)
+	}
 	ctx := context.Background()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	// SIGHUP reloads the configuration; see reloadConfig.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
-	tp, err := setupOpenTelemetry(ctx, cfg)
+	sampler := telemetry.NewSampler()
+	if err := applyLive(cfg, sampler); err != nil {
+		log.Fatalf("[CONFIG] %v", err)
+	}
+	res, err := telemetry.NewResource(ctx, cfg, "grpc-server")
+	if err != nil {
+		log.Fatalf("failed to set up tracer: %v", err)
+	}
+	tp, exporterCheck, err := setupOpenTelemetry(ctx, cfg, res, sampler)
 	if err != nil {
 		log.Fatalf("failed to set up tracer: %v", err)
 	}
 	defer func() { _ = tp.Shutdown(ctx) }()
-
-	creds, err := security.LoadTLSCredentials(cfg)
+	mp, err := setupMeterProvider(ctx, cfg, res, prometheus.DefaultRegisterer)
+	if err != nil {
+		log.Fatalf("failed to set up meter provider: %v", err)
+	}
+	defer func() { _ = mp.Shutdown(ctx) }()
+
+	// Certificates and the client CA are re-read when the files change, so
+	// rotated certs apply to new handshakes without dropping in-flight RPCs.
+	// With SPIFFE, the SVID and bundle follow the agent instead.
+	security.RegisterMetrics(prometheus.DefaultRegisterer)
+	telemetry.RegisterMetrics(prometheus.DefaultRegisterer)
+	telemetry.RegisterTargetInfo(prometheus.DefaultRegisterer, res)
+	prometheus.MustRegister(configReloads, configRestartRequired)
+	reloadCtx, cancelReload := context.WithCancel(ctx)
+	defer cancelReload()
+	creds, err := security.TransportCredentials(reloadCtx, cfg)
 	if err != nil {
 		log.Fatalf("cannot load TLS credentials: %v", err)
 	}
+	log.Printf("[GRPC] transport mode: %s", cfg.TransportMode)
 
 	metricAddr := ":" + cfg.MetricsPort
 	httpSrv := &http.Server{
-		Addr:    metricAddr,
-		Handler: promhttp.Handler(), // exposes /metrics
+		Addr: metricAddr,
+		// Exposes /metrics; OpenMetrics carries the exemplars.
+		Handler: promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})),
 	}
 
 	go func() {
@@ -58,24 +109,65 @@ func main() {
 	// Dial options:
 	// - WithStatsHandler(otelgrpc.NewClientHandler()) → for tracing outgoing RPCs
 	// - grpcprometheus interceptors → for Prometheus metrics
-	otelServerHandler := otelgrpc.NewServerHandler()
+	// Message events let Jaeger show every message sent/received on Watch streams.
+	otelServerHandler := otelgrpc.NewServerHandler(
+		otelgrpc.WithMessageEvents(otelgrpc.ReceivedEvents, otelgrpc.SentEvents),
+	)
+	// Prometheus interceptors run first so denied calls are counted too.
+	streamInterceptors := []grpc.StreamServerInterceptor{grpcprometheus.StreamServerInterceptor}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{grpcprometheus.UnaryServerInterceptor}
+	// The authorizer is installed even without a policy, so that SIGHUP
+	// can set one.
+	policy, err := loadPolicy(cfg)
+	if err != nil {
+		log.Fatalf("cannot load authorization policy: %v", err)
+	}
+	authorizer := authz.NewAuthorizer(policy)
+	streamInterceptors = append(streamInterceptors, authorizer.StreamServerInterceptor)
+	unaryInterceptors = append(unaryInterceptors, authorizer.UnaryServerInterceptor)
+
 	grpcServer := grpc.NewServer(
-		// mTLS
+		// Plaintext, TLS or mTLS, depending on TRANSPORT_MODE
 		grpc.Creds(creds),
 		// OpenTelemetry interceptor
 		grpc.StatsHandler(otelServerHandler),
-		// Prometheus interceptors
-		grpc.StreamInterceptor(grpcprometheus.StreamServerInterceptor),
-		grpc.UnaryInterceptor(grpcprometheus.UnaryServerInterceptor),
+		// Links the first RPC of each connection to its TLS handshake span
+		grpc.StatsHandler(security.LinkHandshakes()),
+		// Prometheus and authorization interceptors
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
 	)
 
 	svc := service.NewService()
 	monitoringpb.RegisterMonitoringServiceServer(grpcServer, svc)
 	reflection.Register(grpcServer)
 
+	// grpc.health.v1.Health: "" is the overall status, and each service is
+	// reported under its full name. All flip to NOT_SERVING on shutdown or
+	// while a dependency is failing.
+	healthSrv := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthSrv)
+	healthMonitor := serverhealth.NewMonitor(healthSrv, cfg.HealthCheckInterval,
+		"", monitoringpb.MonitoringService_ServiceDesc.ServiceName)
+	if exporterCheck != nil {
+		healthMonitor.AddDependency("trace-exporter", exporterCheck)
+	}
+	healthCtx, cancelHealth := context.WithCancel(ctx)
+	defer cancelHealth()
+	go healthMonitor.Run(healthCtx)
+
 	grpcprometheus.Register(grpcServer)
 	grpcprometheus.EnableHandlingTimeHistogram()
 
+	// Sampler overrides for methods this server does not serve are logged.
+	var methods []string
+	for name, info := range grpcServer.GetServiceInfo() {
+		for _, m := range info.Methods {
+			methods = append(methods, "/"+name+"/"+m.Name)
+		}
+	}
+	sampler.SetMethods(methods)
+
 	grpcAddr := ":" + cfg.GRPCPort
 	listener, err := net.Listen("tcp", grpcAddr)
 	if err != nil {
@@ -89,9 +181,38 @@ func main() {
 		}
 	}()
 
-	<-stop
+wait:
+	for {
+		select {
+		case <-hup:
+			log.Println("[MAIN] SIGHUP received, reloading configuration")
+			reloadConfig(cfg, func(next *config.Config) error {
+				policy, err := loadPolicy(next)
+				if err != nil {
+					return fmt.Errorf("cannot load authorization policy: %w", err)
+				}
+				if err := applyLive(next, sampler); err != nil {
+					return err
+				}
+				authorizer.SetPolicy(policy)
+				if policy == nil && cfg.AuthzPolicyFile != "" {
+					log.Println("[AUTHZ] authorization policy removed; every RPC is allowed")
+				}
+				return nil
+			})
+		case <-stop:
+			break wait
+		}
+	}
 	log.Println("[MAIN] shutdown signal received, stopping servers...")
 
+	cancelHealth()
+	healthMonitor.Shutdown()
+	if cfg.ShutdownDrainDelay > 0 {
+		log.Printf("[MAIN] draining for %s before stopping", cfg.ShutdownDrainDelay)
+		time.Sleep(cfg.ShutdownDrainDelay)
+	}
+
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
@@ -105,3 +226,21 @@ func main() {
 
 	log.Println("[MAIN] All servers have shut down. Exiting.")
 }
+
+// loadPolicy reads the authorization policy of cfg, or returns nil if
+// there is none, and logs what is enforced.
+func loadPolicy(cfg *config.Config) (*authz.Policy, error) {
+	if cfg.AuthzPolicyFile == "" {
+		return nil, nil
+	}
+	policy, err := authz.LoadPolicy(cfg.AuthzPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[AUTHZ] enforcing policy from %s", cfg.AuthzPolicyFile)
+	if cfg.TransportMode != config.TransportMTLS {
+		log.Printf("[AUTHZ] TRANSPORT_MODE=%s has no client certificates; only methods the policy allows anonymously can be called",
+			cfg.TransportMode)
+	}
+	return policy, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"server/internal/config"
+	"server/internal/logging"
+	"server/internal/security"
+	"server/internal/telemetry"
+)
+
+// liveSettings are applied when SIGHUP reloads the configuration; a
+// change to any other setting is reported and waits for a restart. The
+// TLS files and the authorization policy are re-read on every SIGHUP.
+var liveSettings = []string{"log_level", "traces_sampler", "trace_sample_ratio", "traces_sampler_overrides", "authz_policy_file"}
+
+var (
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_reloads_total",
+		Help: "Number of configuration reloads triggered by SIGHUP, by result (success or failure)",
+	}, []string{"result"})
+	configRestartRequired = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "config_restart_required",
+		Help: "Whether the configuration last reloaded changed settings that only apply after a restart (1) or not (0)",
+	})
+)
+
+// applyLive puts the live settings of cfg into effect. The log level only
+// changes once the sampler has accepted cfg, so on error nothing changes.
+func applyLive(cfg *config.Config, sampler *telemetry.Sampler) error {
+	if err := sampler.Configure(cfg); err != nil {
+		return err
+	}
+	logging.SetDebug(cfg.LogLevel == config.LogLevelDebug)
+	return nil
+}
+
+// reloadConfig re-reads the configuration and calls apply with it to put
+// the live settings into effect; apply must change nothing if it fails.
+// Only then is the new configuration stored in cfg. The TLS files are
+// re-read either way, and the outcome is recorded.
+func reloadConfig(cfg *config.Config, apply func(next *config.Config) error) {
+	next, applied, restart, err := cfg.Reload(os.Args[1:], liveSettings...)
+	if err == nil {
+		err = apply(next)
+	}
+	if err != nil {
+		err = errors.Join(err, security.ReloadFiles())
+		configReloads.WithLabelValues("failure").Inc()
+		log.Printf("[CONFIG] reload failed, keeping the previous settings: %v", err)
+		return
+	}
+	*cfg = *next
+
+	if err := security.ReloadFiles(); err != nil {
+		configReloads.WithLabelValues("failure").Inc()
+		log.Printf("[CONFIG] TLS files were not reloaded: %v", err)
+	} else {
+		configReloads.WithLabelValues("success").Inc()
+	}
+	if len(applied) > 0 {
+		log.Printf("[CONFIG] reloaded, applied %s", strings.Join(applied, ", "))
+	} else {
+		log.Println("[CONFIG] reloaded, no live setting changed")
+	}
+	if len(restart) > 0 {
+		configRestartRequired.Set(1)
+		log.Printf("[CONFIG] changed settings need a restart to apply: %s", strings.Join(restart, ", "))
+	} else {
+		configRestartRequired.Set(0)
+	}
+}
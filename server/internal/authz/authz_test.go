@@ -0,0 +1,230 @@
+package authz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	methodMonitoring = "/Monitoring.MonitoringService/Monitoring"
+	methodWatch      = "/Monitoring.MonitoringService/Watch"
+	methodHealth     = "/grpc.health.v1.Health/Check"
+)
+
+const testPolicy = `
+default: deny
+rules:
+  - method: /Monitoring.MonitoringService/*
+    allow:
+      - common_name: grpc-client
+      - uri: spiffe://example.org/ns/prod/sa/prober
+  - method: /Monitoring.MonitoringService/Watch
+    allow:
+      - common_name: grpc-client
+        organizational_unit: sre
+  - method: /grpc.health.v1.Health/*
+    allow_any: true
+`
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "authz.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	return path
+}
+
+func TestPolicy_Allowed(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+
+	client := Identity{CommonName: "grpc-client"}
+	sre := Identity{CommonName: "grpc-client", OrganizationalUnits: []string{"sre"}}
+	prober := Identity{CommonName: "prober", URIs: []string{"spiffe://example.org/ns/prod/sa/prober"}}
+	other := Identity{CommonName: "billing", OrganizationalUnits: []string{"sre"}}
+
+	tests := []struct {
+		name   string
+		method string
+		id     Identity
+		want   bool
+	}{
+		{name: "service rule by CN", method: methodMonitoring, id: client, want: true},
+		{name: "service rule by SAN URI", method: methodMonitoring, id: prober, want: true},
+		{name: "service rule rejects others", method: methodMonitoring, id: other, want: false},
+		{name: "method rule is more specific", method: methodWatch, id: prober, want: false},
+		{name: "method rule needs every field", method: methodWatch, id: client, want: false},
+		{name: "method rule by CN and OU", method: methodWatch, id: sre, want: true},
+		{name: "allow_any", method: methodHealth, id: other, want: true},
+		{name: "default deny", method: "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", id: sre, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, reason := policy.Allowed(tc.method, tc.id)
+			if got != tc.want {
+				t.Errorf("Allowed(%s, %s) = %v (%s), want %v", tc.method, tc.id, got, reason, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy_Invalid(t *testing.T) {
+	path := writePolicy(t, `
+default: maybe
+rules:
+  - method: Monitoring
+    allow:
+      - common_name: a
+  - method: "*"
+  - method: "*"
+    allow:
+      - {}
+`)
+
+	_, err := LoadPolicy(path)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	for _, want := range []string{
+		`default must be "deny" or "allow"`,
+		`method "Monitoring" must look like`,
+		"allow must not be empty",
+		`duplicate method "*"`,
+		"rules[2].allow[0]: at least one of",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got: %v", want, err)
+		}
+	}
+
+	if _, err := LoadPolicy("/nonexistent/authz.yaml"); err == nil {
+		t.Error("expected error for missing file, got nil")
+	}
+}
+
+// peerContext returns a context carrying a peer whose verified chain starts
+// with a certificate for cn, uris and ous.
+func peerContext(cn string, uris []string, ous []string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn, OrganizationalUnit: ous}}
+	for _, u := range uris {
+		parsed, _ := url.Parse(u)
+		cert.URIs = append(cert.URIs, parsed)
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert}},
+		}},
+	})
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context { return s.ctx }
+
+func TestAuthorizer_Interceptors(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	a := NewAuthorizer(policy)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		wantCode codes.Code
+	}{
+		{name: "allowed", ctx: peerContext("grpc-client", nil, nil), method: methodMonitoring, wantCode: codes.OK},
+		{name: "denied", ctx: peerContext("billing", nil, nil), method: methodMonitoring, wantCode: codes.PermissionDenied},
+		{name: "SPIFFE ID", ctx: peerContext("", []string{"spiffe://example.org/ns/prod/sa/prober"}, nil), method: methodMonitoring, wantCode: codes.OK},
+		{name: "no peer", ctx: context.Background(), method: methodMonitoring, wantCode: codes.PermissionDenied},
+		{name: "no peer on allow_any", ctx: context.Background(), method: methodHealth, wantCode: codes.PermissionDenied},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			unary := func(context.Context, any) (any, error) {
+				called = true
+				return "ok", nil
+			}
+			_, err := a.UnaryServerInterceptor(tc.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, unary)
+			if code := status.Code(err); code != tc.wantCode {
+				t.Errorf("unary: got %s, want %s (err: %v)", code, tc.wantCode, err)
+			}
+			if called != (tc.wantCode == codes.OK) {
+				t.Errorf("unary handler called = %v", called)
+			}
+
+			called = false
+			stream := func(any, grpc.ServerStream) error {
+				called = true
+				return nil
+			}
+			err = a.StreamServerInterceptor(nil, &testServerStream{ctx: tc.ctx}, &grpc.StreamServerInfo{FullMethod: tc.method}, stream)
+			if code := status.Code(err); code != tc.wantCode {
+				t.Errorf("stream: got %s, want %s (err: %v)", code, tc.wantCode, err)
+			}
+			if called != (tc.wantCode == codes.OK) {
+				t.Errorf("stream handler called = %v", called)
+			}
+		})
+	}
+
+}
+
+func TestAuthorizer_SetPolicy(t *testing.T) {
+	path := writePolicy(t, testPolicy)
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	a := NewAuthorizer(policy)
+	billing := peerContext("billing", nil, nil)
+	if err := a.Authorize(billing, methodMonitoring); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected the policy to deny billing, got: %v", err)
+	}
+
+	// A rewritten policy file applies to the next call once reloaded.
+	if err := os.WriteFile(path, []byte(strings.Replace(testPolicy, "common_name: grpc-client\n      - uri", "common_name: billing\n      - uri", 1)), 0o644); err != nil {
+		t.Fatalf("failed to rewrite policy file: %v", err)
+	}
+	if policy, err = LoadPolicy(path); err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	a.SetPolicy(policy)
+	if err := a.Authorize(billing, methodMonitoring); err != nil {
+		t.Errorf("expected the reloaded policy to allow billing, got: %v", err)
+	}
+	if err := a.Authorize(peerContext("grpc-client", nil, nil), methodMonitoring); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected the reloaded policy to deny grpc-client, got: %v", err)
+	}
+
+	a.SetPolicy(nil)
+	if err := a.Authorize(context.Background(), methodWatch); err != nil {
+		t.Errorf("expected no policy to allow every call, got: %v", err)
+	}
+}
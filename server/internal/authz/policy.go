@@ -0,0 +1,170 @@
+// Package authz decides which verified mTLS clients may call which RPC.
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is the layout of the file pointed to by AUTHZ_POLICY_FILE.
+type Policy struct {
+	// Default is "deny" (the default) or "allow" and applies to methods no
+	// rule matches.
+	Default string `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Rule grants access to the methods matching Method.
+type Rule struct {
+	// Method is a full method name ("/Monitoring.MonitoringService/Watch"),
+	// every method of a service ("/Monitoring.MonitoringService/*") or "*".
+	// When several rules match, the most specific one applies.
+	Method string `yaml:"method"`
+	// AllowAny lets every client whose certificate passed verification in.
+	AllowAny bool        `yaml:"allow_any"`
+	Allow    []Principal `yaml:"allow"`
+}
+
+// Principal matches a client certificate. Every non-empty field must match;
+// a rule allows a client if any of its principals does.
+type Principal struct {
+	CommonName string `yaml:"common_name"`
+	// URI must equal one of the certificate's SAN URIs, such as a SPIFFE ID.
+	URI                string `yaml:"uri"`
+	OrganizationalUnit string `yaml:"organizational_unit"`
+}
+
+// Identity is what the server knows about a verified client.
+type Identity struct {
+	CommonName          string
+	URIs                []string
+	OrganizationalUnits []string
+}
+
+func (id Identity) String() string {
+	return fmt.Sprintf("cn=%q uris=%q ou=%q", id.CommonName, id.URIs, id.OrganizationalUnits)
+}
+
+// LoadPolicy reads and validates a policy file. All problems are reported
+// together.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("authz: could not read policy file (%s): %w", path, err)
+	}
+
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("authz: could not parse policy file (%s): %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("authz: invalid policy file (%s):\n%w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	var errs []error
+
+	switch p.Default {
+	case "":
+		p.Default = "deny"
+	case "deny", "allow":
+	default:
+		errs = append(errs, fmt.Errorf("default must be \"deny\" or \"allow\", got %q", p.Default))
+	}
+
+	seen := make(map[string]bool)
+	for i, r := range p.Rules {
+		switch {
+		case r.Method == "*":
+		case !strings.HasPrefix(r.Method, "/") || strings.Count(r.Method, "/") != 2:
+			errs = append(errs, fmt.Errorf("rules[%d]: method %q must look like /package.Service/Method, /package.Service/* or *", i, r.Method))
+		}
+		if seen[r.Method] {
+			errs = append(errs, fmt.Errorf("rules[%d]: duplicate method %q", i, r.Method))
+		}
+		seen[r.Method] = true
+
+		if !r.AllowAny && len(r.Allow) == 0 {
+			errs = append(errs, fmt.Errorf("rules[%d] (%s): allow must not be empty unless allow_any is set", i, r.Method))
+		}
+		for j, pr := range r.Allow {
+			if pr == (Principal{}) {
+				errs = append(errs, fmt.Errorf("rules[%d].allow[%d]: at least one of common_name, uri or organizational_unit is required", i, j))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Allowed reports whether id may call fullMethod, and why not if it may not.
+func (p *Policy) Allowed(fullMethod string, id Identity) (bool, string) {
+	rule := p.match(fullMethod)
+	if rule == nil {
+		if p.Default == "allow" {
+			return true, ""
+		}
+		return false, "no rule for method and default is deny"
+	}
+	if rule.AllowAny {
+		return true, ""
+	}
+	for _, pr := range rule.Allow {
+		if pr.matches(id) {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("identity not allowed by rule %q", rule.Method)
+}
+
+// AllowedAnonymous reports whether a client without a verified certificate
+// may call fullMethod: only methods no rule covers, under default "allow".
+func (p *Policy) AllowedAnonymous(fullMethod string) bool {
+	return p.match(fullMethod) == nil && p.Default == "allow"
+}
+
+// match returns the most specific rule for fullMethod, or nil.
+func (p *Policy) match(fullMethod string) *Rule {
+	service := fullMethod
+	if i := strings.LastIndex(fullMethod, "/"); i > 0 {
+		service = fullMethod[:i]
+	}
+
+	var best *Rule
+	bestRank := 0
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		rank := 0
+		switch r.Method {
+		case fullMethod:
+			rank = 3
+		case service + "/*":
+			rank = 2
+		case "*":
+			rank = 1
+		}
+		if rank > bestRank {
+			best, bestRank = r, rank
+		}
+	}
+	return best
+}
+
+func (pr Principal) matches(id Identity) bool {
+	if pr.CommonName != "" && pr.CommonName != id.CommonName {
+		return false
+	}
+	if pr.URI != "" && !slices.Contains(id.URIs, pr.URI) {
+		return false
+	}
+	if pr.OrganizationalUnit != "" && !slices.Contains(id.OrganizationalUnits, pr.OrganizationalUnit) {
+		return false
+	}
+	return true
+}
@@ -1,7 +1,83 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport modes accepted in TRANSPORT_MODE.
+const (
+	// TransportPlaintext disables TLS; for local development only.
+	TransportPlaintext = "plaintext"
+	// TransportTLS authenticates the server only.
+	TransportTLS = "tls"
+	// TransportMTLS authenticates both sides; the default.
+	TransportMTLS = "mtls"
+)
+
+// TLS policy presets accepted in TLS_POLICY.
+const (
+	// TLSPolicyDefault allows TLS 1.2 and 1.3 with Go's default suites.
+	TLSPolicyDefault = "default"
+	// TLSPolicyStrict allows TLS 1.3 only.
+	TLSPolicyStrict = "strict"
+)
+
+// Trace exporters accepted in TRACES_EXPORTER. Empty picks
+// TracesExporterOTLP if OTLPCollectorEndpoint is set and
+// TracesExporterNone otherwise.
+const (
+	TracesExporterNone   = "none"
+	TracesExporterStdout = "stdout"
+	TracesExporterOTLP   = "otlp"
+)
+
+// Metrics exporters accepted in METRICS_EXPORTER, named as in
+// OTEL_METRICS_EXPORTER.
+const (
+	MetricsExporterPrometheus = "prometheus"
+	MetricsExporterOTLP       = "otlp"
+	MetricsExporterNone       = "none"
+)
+
+// Samplers accepted in TRACES_SAMPLER, named as in OTEL_TRACES_SAMPLER.
+// The traceidratio samplers sample TraceSampleRatio of new traces; the
+// parentbased ones follow the decision of the parent span, if any.
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+)
+
+// SamplerErrors is accepted in TRACES_SAMPLER_OVERRIDES only: calls are
+// sampled as usual, and every failing call is kept as well.
+const SamplerErrors = "errors"
+
+// OTLP protocols accepted in OTLP_PROTOCOL, named as in
+// OTEL_EXPORTER_OTLP_PROTOCOL.
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+)
+
+// OTLP compressions accepted in OTLP_COMPRESSION.
+const (
+	OTLPCompressionNone = "none"
+	OTLPCompressionGzip = "gzip"
+)
+
+// Log levels accepted in LOG_LEVEL.
+const (
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
 )
 
 type Config struct {
@@ -11,22 +87,244 @@ type Config struct {
 	TLSKeyFile            string
 	TLSCAFile             string
 	OTLPCollectorEndpoint string
+	// TLSCRLFile holds PEM or DER CRLs checked against client certificates
+	// in mTLS mode; empty disables revocation checking.
+	TLSCRLFile string
+	// TLSReloadInterval is how often the TLS files are checked for changes;
+	// zero disables reloading.
+	TLSReloadInterval time.Duration
+	// HealthCheckInterval is how often dependencies such as the OTLP
+	// exporter are checked to update the gRPC health service.
+	HealthCheckInterval time.Duration
+	// ShutdownDrainDelay is how long the server keeps serving after it has
+	// reported NOT_SERVING, giving load balancers time to notice.
+	ShutdownDrainDelay time.Duration
+	// AuthzPolicyFile is the per-method allowlist of client identities;
+	// empty lets every client with a verified certificate call every RPC.
+	AuthzPolicyFile string
+	// SPIFFEEndpointSocket is the SPIFFE Workload API address, such as
+	// unix:///run/spire/agent.sock. When set, the SVID and trust bundle
+	// from the agent replace the TLS_* files.
+	SPIFFEEndpointSocket string
+	// SPIFFESVIDDir is a directory an agent keeps svid.pem, svid_key.pem
+	// and svid_bundle.pem up to date in; an alternative to the socket.
+	SPIFFESVIDDir string
+	// SPIFFEAllowedIDs are the SPIFFE IDs accepted from the peer; empty
+	// accepts any ID in the trust domain of our own SVID.
+	SPIFFEAllowedIDs []string
+	// TransportMode is TransportPlaintext, TransportTLS or TransportMTLS.
+	TransportMode string
+	// TLSPolicy is TLSPolicyDefault or TLSPolicyStrict. It and the other
+	// policy settings below are validated by security.ParsePolicy.
+	TLSPolicy string
+	// TLSMinVersion and TLSMaxVersion are "1.2" or "1.3"; empty uses the
+	// bounds of TLSPolicy.
+	TLSMinVersion string
+	TLSMaxVersion string
+	// TLSCipherSuites are IANA names of the TLS 1.2 cipher suites to allow;
+	// empty uses Go's defaults. TLS 1.3 suites cannot be configured.
+	TLSCipherSuites []string
+	// TLSCurves are the key exchange groups to offer, in order of
+	// preference, such as X25519 or P-256; empty uses Go's defaults.
+	TLSCurves []string
+	// TLSALPN are the ALPN protocols to negotiate; it must include h2,
+	// which gRPC requires. Empty means h2 only.
+	TLSALPN []string
+	// LogLevel is LogLevelInfo or LogLevelDebug, which adds a line per
+	// TLS handshake.
+	LogLevel string
+	// DeploymentEnvironment is reported as the deployment.environment
+	// resource attribute, such as production or staging.
+	DeploymentEnvironment string
+	// ServiceInstanceID is reported as service.instance.id; empty means a
+	// random UUID for each run.
+	ServiceInstanceID string
+	// TracesExporter is empty or one of the TracesExporter constants.
+	TracesExporter string
+	// TracesFile is where TracesExporterStdout appends spans as JSON
+	// lines; empty means standard output.
+	TracesFile string
+	// MetricsExporter lists where the OpenTelemetry metrics, such as
+	// rpc.server.duration, go: MetricsExporterPrometheus serves them on
+	// /metrics and MetricsExporterOTLP pushes them to the collector every
+	// MetricsExportInterval. MetricsExporterNone, or an empty list, turns
+	// them off.
+	MetricsExporter       []string
+	MetricsExportInterval time.Duration
+	// OTLPProtocol is OTLPProtocolGRPC, the default, or OTLPProtocolHTTP.
+	// The collector is reached over TLS when OTLPCollectorEndpoint is an
+	// https:// URL or any of the OTLP TLS files is set.
+	OTLPProtocol string
+	// OTLPCAFile verifies the collector certificate; empty uses the system
+	// roots.
+	OTLPCAFile string
+	// OTLPCertFile and OTLPKeyFile are the client certificate for
+	// collectors that require mTLS.
+	OTLPCertFile string
+	OTLPKeyFile  string
+	// OTLPHeaders are sent with every export, typically an authorization
+	// header with a bearer token.
+	OTLPHeaders Headers
+	// OTLPCompression is OTLPCompressionNone, the default, or
+	// OTLPCompressionGzip.
+	OTLPCompression string
+	// OTLPTimeout bounds each export to the collector; zero keeps the
+	// exporter default of 10s.
+	OTLPTimeout time.Duration
+	// TracesSampler is empty or one of the Sampler constants; empty means
+	// SamplerParentBasedTraceIDRatio.
+	TracesSampler string
+	// TraceSampleRatio is the fraction of new traces sampled by the
+	// traceidratio samplers, from 0 to 1.
+	TraceSampleRatio float64
+	// TracesSamplerOverrides are "method=sampler" pairs that replace
+	// TracesSampler for some gRPC methods; see ParseSamplerOverride.
+	TracesSamplerOverrides []string
+}
+
+// legacyTLSFiles maps the default TLS files to the names they had before
+// certgen wrote .pem files: certs/server.crt, certs/server.key and
+// certs/ca.crt.
+var legacyTLSFiles = map[string]string{
+	"certs/server.crt.pem": "certs/server.crt",
+	"certs/server.key.pem": "certs/server.key",
+	"certs/ca.crt.pem":     "certs/ca.crt",
+}
+
+// useLegacyTLSFiles switches each TLS file left at its default to its
+// legacy name when only the file under that name exists, so deployments
+// that kept the old files still start.
+func (c *Config) useLegacyTLSFiles() {
+	for _, path := range []*string{&c.TLSCertFile, &c.TLSKeyFile, &c.TLSCAFile} {
+		legacy, ok := legacyTLSFiles[*path]
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(*path); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if validateFile(legacy) == nil {
+			*path = legacy
+		}
+	}
+}
+
+// LegacyTLSFiles returns the TLS files that use a legacy default name, and
+// should be renamed to end in .pem.
+func (c *Config) LegacyTLSFiles() []string {
+	var paths []string
+	for _, path := range []string{c.TLSCertFile, c.TLSKeyFile, c.TLSCAFile} {
+		for _, legacy := range legacyTLSFiles {
+			if path == legacy {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
 }
 
-func LoadConfig() *Config {
+// Default returns the configuration used for settings that are not set
+// in the config file, the environment or on the command line.
+func Default() *Config {
 	return &Config{
-		GRPCPort:              getEnv("GRPC_PORT", "50051"),
-		MetricsPort:           getEnv("METRICS_PORT", "2025"),
-		TLSCertFile:           getEnv("TLS_CERT_FILE", "certs/server.crt"),
-		TLSKeyFile:            getEnv("TLS_KEY_FILE", "certs/server.key"),
-		TLSCAFile:             getEnv("TLS_CA_FILE", "certs/ca.crt"),
-		OTLPCollectorEndpoint: getEnv("OTLP_COLLECTOR_ENDPOINT", ""),
+		GRPCPort:              "50051",
+		MetricsPort:           "2025",
+		TLSCertFile:           "certs/server.crt.pem",
+		TLSKeyFile:            "certs/server.key.pem",
+		TLSCAFile:             "certs/ca.crt.pem",
+		TLSReloadInterval:     30 * time.Second,
+		HealthCheckInterval:   10 * time.Second,
+		TransportMode:         TransportMTLS,
+		TLSPolicy:             TLSPolicyDefault,
+		OTLPProtocol:          OTLPProtocolGRPC,
+		OTLPCompression:       OTLPCompressionNone,
+		OTLPTimeout:           10 * time.Second,
+		MetricsExporter:       []string{MetricsExporterPrometheus},
+		MetricsExportInterval: time.Minute,
+		LogLevel:              LogLevelInfo,
+		TracesSampler:         SamplerParentBasedTraceIDRatio,
+		TraceSampleRatio:      1,
 	}
 }
 
-func getEnv(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// TracesExporterName resolves an empty TracesExporter to its default.
+func (c *Config) TracesExporterName() string {
+	switch {
+	case c.TracesExporter != "":
+		return c.TracesExporter
+	case c.OTLPCollectorEndpoint != "":
+		return TracesExporterOTLP
+	}
+	return TracesExporterNone
+}
+
+// OTLPTLSEnabled reports whether the collector is reached over TLS.
+func (c *Config) OTLPTLSEnabled() bool {
+	return strings.HasPrefix(c.OTLPCollectorEndpoint, "https://") ||
+		c.OTLPCAFile != "" || c.OTLPCertFile != ""
+}
+
+// SPIFFEEnabled reports whether the workload identity comes from SPIFFE
+// rather than the TLS_* files.
+func (c *Config) SPIFFEEnabled() bool {
+	return c.SPIFFEEndpointSocket != "" || c.SPIFFESVIDDir != ""
+}
+
+// Headers are "name=value" pairs in the format of
+// OTEL_EXPORTER_OTLP_HEADERS: values may be percent-encoded, such as
+// authorization=Bearer%20<token>. Print masks the values.
+type Headers []string
+
+// Map returns the headers by name. Pairs without "=" are skipped; Validate
+// reports them.
+func (h Headers) Map() map[string]string {
+	m := make(map[string]string, len(h))
+	for _, pair := range h {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if v, err := url.PathUnescape(strings.TrimSpace(value)); err == nil {
+			value = v
+		}
+		m[strings.TrimSpace(name)] = value
+	}
+	return m
+}
+
+// SamplerOverride is an entry of TracesSamplerOverrides.
+type SamplerOverride struct {
+	// Method is a full gRPC method name, /package.Service/Method, or
+	// /package.Service/* for every method of a service.
+	Method string
+	// Sampler is SamplerAlwaysOn, SamplerAlwaysOff, SamplerErrors or
+	// SamplerTraceIDRatio with Ratio.
+	Sampler string
+	Ratio   float64
+}
+
+// ParseSamplerOverride parses "method=sampler", where sampler is
+// always_on, always_off, errors or a ratio from 0 to 1, such as
+// /Monitoring.MonitoringService/Monitoring=errors.
+func ParseSamplerOverride(entry string) (SamplerOverride, error) {
+	method, value, ok := strings.Cut(entry, "=")
+	if !ok {
+		return SamplerOverride{}, fmt.Errorf("%q is not a method=sampler pair", entry)
+	}
+	o := SamplerOverride{Method: strings.TrimSpace(method), Sampler: strings.TrimSpace(value)}
+	service, name, ok := strings.Cut(strings.TrimPrefix(o.Method, "/"), "/")
+	if !strings.HasPrefix(o.Method, "/") || !ok || service == "" || name == "" || strings.Contains(name, "/") {
+		return SamplerOverride{}, fmt.Errorf("%q is not a method such as /package.Service/Method or /package.Service/*", o.Method)
+	}
+
+	switch o.Sampler {
+	case SamplerAlwaysOn, SamplerAlwaysOff, SamplerErrors:
+		return o, nil
+	}
+	ratio, err := strconv.ParseFloat(o.Sampler, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return SamplerOverride{}, fmt.Errorf("%s: %q must be always_on, always_off, errors or a ratio from 0 to 1", o.Method, o.Sampler)
 	}
-	return fallback
+	o.Sampler, o.Ratio = SamplerTraceIDRatio, ratio
+	return o, nil
 }
@@ -0,0 +1,142 @@
+// Package health keeps the standard grpc.health.v1.Health service in sync
+// with the state of the server and of the dependencies it relies on.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Check reports whether a dependency is usable; a non-nil error marks the
+// services that depend on it NOT_SERVING.
+type Check func(ctx context.Context) error
+
+type dependency struct {
+	name     string
+	check    Check
+	services []string
+}
+
+// Monitor periodically runs dependency checks and updates the per-service
+// serving status of a health.Server.
+type Monitor struct {
+	server   *health.Server
+	services []string
+	interval time.Duration
+
+	mu       sync.Mutex
+	deps     []dependency
+	status   map[string]healthpb.HealthCheckResponse_ServingStatus
+	shutdown bool
+}
+
+// NewMonitor marks services (use "" for the overall server status) as
+// SERVING on server and returns a monitor that re-evaluates them every
+// interval once Run is called.
+func NewMonitor(server *health.Server, interval time.Duration, services ...string) *Monitor {
+	m := &Monitor{
+		server:   server,
+		services: services,
+		interval: interval,
+		status:   make(map[string]healthpb.HealthCheckResponse_ServingStatus),
+	}
+	for _, svc := range services {
+		m.set(svc, healthpb.HealthCheckResponse_SERVING, "")
+	}
+	return m
+}
+
+// AddDependency registers a check. When it fails, the given services are
+// marked NOT_SERVING; with no services listed, every monitored service is.
+func (m *Monitor) AddDependency(name string, check Check, services ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deps = append(m.deps, dependency{name: name, check: check, services: services})
+}
+
+// Run evaluates the dependencies every interval until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context) {
+	m.Evaluate(ctx)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Evaluate(ctx)
+		}
+	}
+}
+
+// Evaluate runs every dependency check once and updates the serving status
+// of the monitored services.
+func (m *Monitor) Evaluate(ctx context.Context) {
+	m.mu.Lock()
+	deps := append([]dependency(nil), m.deps...)
+	m.mu.Unlock()
+
+	failures := make(map[string][]error)
+	for _, dep := range deps {
+		checkCtx, cancel := context.WithTimeout(ctx, m.interval)
+		err := dep.check(checkCtx)
+		cancel()
+		if err == nil {
+			continue
+		}
+		affected := dep.services
+		if len(affected) == 0 {
+			affected = m.services
+		}
+		for _, svc := range affected {
+			failures[svc] = append(failures[svc], fmt.Errorf("%s: %w", dep.name, err))
+		}
+	}
+
+	for _, svc := range m.services {
+		if errs := failures[svc]; len(errs) > 0 {
+			m.set(svc, healthpb.HealthCheckResponse_NOT_SERVING, errors.Join(errs...).Error())
+		} else {
+			m.set(svc, healthpb.HealthCheckResponse_SERVING, "")
+		}
+	}
+}
+
+// Shutdown marks every service NOT_SERVING and ignores later updates. Call
+// it before GracefulStop so load balancers stop sending new RPCs.
+func (m *Monitor) Shutdown() {
+	m.mu.Lock()
+	m.shutdown = true
+	m.mu.Unlock()
+
+	m.server.Shutdown()
+	log.Println("[HEALTH] all services marked NOT_SERVING for shutdown")
+}
+
+func (m *Monitor) set(svc string, st healthpb.HealthCheckResponse_ServingStatus, reason string) {
+	m.mu.Lock()
+	if m.shutdown {
+		m.mu.Unlock()
+		return
+	}
+	prev, known := m.status[svc]
+	m.status[svc] = st
+	m.mu.Unlock()
+
+	m.server.SetServingStatus(svc, st)
+	if known && prev != st {
+		if reason != "" {
+			log.Printf("[HEALTH] service %q is now %s: %s", svc, st, reason)
+		} else {
+			log.Printf("[HEALTH] service %q is now %s", svc, st)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const monitoringService = "Monitoring.MonitoringService"
+
+func servingStatus(t *testing.T, hs *health.Server, svc string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: svc})
+	if err != nil {
+		t.Fatalf("Check(%q) returned error: %v", svc, err)
+	}
+	return resp.GetStatus()
+}
+
+func TestMonitor(t *testing.T) {
+	hs := health.NewServer()
+	m := NewMonitor(hs, time.Second, "", monitoringService)
+
+	var exporterDown, dbDown atomic.Bool
+	m.AddDependency("otlp-exporter", func(context.Context) error {
+		if exporterDown.Load() {
+			return errors.New("connection is in TRANSIENT_FAILURE")
+		}
+		return nil
+	})
+	m.AddDependency("database", func(context.Context) error {
+		if dbDown.Load() {
+			return errors.New("unreachable")
+		}
+		return nil
+	}, monitoringService)
+
+	tests := []struct {
+		name           string
+		exporterDown   bool
+		dbDown         bool
+		wantOverall    healthpb.HealthCheckResponse_ServingStatus
+		wantMonitoring healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{
+			name:           "all healthy",
+			wantOverall:    healthpb.HealthCheckResponse_SERVING,
+			wantMonitoring: healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			name:           "dependency of every service down",
+			exporterDown:   true,
+			wantOverall:    healthpb.HealthCheckResponse_NOT_SERVING,
+			wantMonitoring: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			name:           "dependency of one service down",
+			dbDown:         true,
+			wantOverall:    healthpb.HealthCheckResponse_SERVING,
+			wantMonitoring: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			name:           "recovered",
+			wantOverall:    healthpb.HealthCheckResponse_SERVING,
+			wantMonitoring: healthpb.HealthCheckResponse_SERVING,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			exporterDown.Store(tc.exporterDown)
+			dbDown.Store(tc.dbDown)
+			m.Evaluate(context.Background())
+
+			if got := servingStatus(t, hs, ""); got != tc.wantOverall {
+				t.Errorf("overall status: got %s, want %s", got, tc.wantOverall)
+			}
+			if got := servingStatus(t, hs, monitoringService); got != tc.wantMonitoring {
+				t.Errorf("%s status: got %s, want %s", monitoringService, got, tc.wantMonitoring)
+			}
+		})
+	}
+
+	t.Run("shutdown", func(t *testing.T) {
+		m.Shutdown()
+		m.Evaluate(context.Background())
+
+		for _, svc := range []string{"", monitoringService} {
+			if got := servingStatus(t, hs, svc); got != healthpb.HealthCheckResponse_NOT_SERVING {
+				t.Errorf("%q after shutdown: got %s, want NOT_SERVING", svc, got)
+			}
+		}
+	})
+}
@@ -20,6 +20,8 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	MonitoringService_Monitoring_FullMethodName = "/Monitoring.MonitoringService/Monitoring"
+	MonitoringService_Watch_FullMethodName      = "/Monitoring.MonitoringService/Watch"
+	MonitoringService_PingStream_FullMethodName = "/Monitoring.MonitoringService/PingStream"
 )
 
 // MonitoringServiceClient is the client API for MonitoringService service.
@@ -27,6 +29,8 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MonitoringServiceClient interface {
 	Monitoring(ctx context.Context, in *MonitoringClientRequest, opts ...grpc.CallOption) (*MonitoringServerResponse, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Heartbeat], error)
+	PingStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Client, PingStreamResponse], error)
 }
 
 type monitoringServiceClient struct {
@@ -47,11 +51,45 @@ func (c *monitoringServiceClient) Monitoring(ctx context.Context, in *Monitoring
 	return out, nil
 }
 
+func (c *monitoringServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Heartbeat], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MonitoringService_ServiceDesc.Streams[0], MonitoringService_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, Heartbeat]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MonitoringService_WatchClient = grpc.ServerStreamingClient[Heartbeat]
+
+func (c *monitoringServiceClient) PingStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Client, PingStreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MonitoringService_ServiceDesc.Streams[1], MonitoringService_PingStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Client, PingStreamResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MonitoringService_PingStreamClient = grpc.BidiStreamingClient[Client, PingStreamResponse]
+
 // MonitoringServiceServer is the server API for MonitoringService service.
 // All implementations must embed UnimplementedMonitoringServiceServer
 // for forward compatibility.
 type MonitoringServiceServer interface {
 	Monitoring(context.Context, *MonitoringClientRequest) (*MonitoringServerResponse, error)
+	Watch(*WatchRequest, grpc.ServerStreamingServer[Heartbeat]) error
+	PingStream(grpc.BidiStreamingServer[Client, PingStreamResponse]) error
 	mustEmbedUnimplementedMonitoringServiceServer()
 }
 
@@ -65,6 +103,12 @@ type UnimplementedMonitoringServiceServer struct{}
 func (UnimplementedMonitoringServiceServer) Monitoring(context.Context, *MonitoringClientRequest) (*MonitoringServerResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Monitoring not implemented")
 }
+func (UnimplementedMonitoringServiceServer) Watch(*WatchRequest, grpc.ServerStreamingServer[Heartbeat]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedMonitoringServiceServer) PingStream(grpc.BidiStreamingServer[Client, PingStreamResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method PingStream not implemented")
+}
 func (UnimplementedMonitoringServiceServer) mustEmbedUnimplementedMonitoringServiceServer() {}
 func (UnimplementedMonitoringServiceServer) testEmbeddedByValue()                           {}
 
@@ -104,6 +148,24 @@ func _MonitoringService_Monitoring_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MonitoringService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MonitoringServiceServer).Watch(m, &grpc.GenericServerStream[WatchRequest, Heartbeat]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MonitoringService_WatchServer = grpc.ServerStreamingServer[Heartbeat]
+
+func _MonitoringService_PingStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MonitoringServiceServer).PingStream(&grpc.GenericServerStream[Client, PingStreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MonitoringService_PingStreamServer = grpc.BidiStreamingServer[Client, PingStreamResponse]
+
 // MonitoringService_ServiceDesc is the grpc.ServiceDesc for MonitoringService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -116,6 +178,18 @@ var MonitoringService_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _MonitoringService_Monitoring_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _MonitoringService_Watch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PingStream",
+			Handler:       _MonitoringService_PingStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "Monitoring.proto",
 }
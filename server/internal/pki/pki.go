@@ -0,0 +1,207 @@
+// Package pki issues the CA, server and client certificates of a local
+// development PKI.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Key algorithms accepted by GenerateKey.
+const (
+	RSA     = "rsa"
+	ECDSA   = "ecdsa"
+	Ed25519 = "ed25519"
+)
+
+// GenerateKey returns a new private key. rsaBits only applies to RSA;
+// ECDSA keys use P-256.
+func GenerateKey(algorithm string, rsaBits int) (crypto.Signer, error) {
+	switch algorithm {
+	case RSA:
+		if rsaBits < 2048 {
+			return nil, fmt.Errorf("pki: RSA keys need at least 2048 bits, got %d", rsaBits)
+		}
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case ECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("pki: unknown key algorithm %q (want %s, %s or %s)", algorithm, RSA, ECDSA, Ed25519)
+}
+
+// Template describes a leaf certificate.
+type Template struct {
+	Subject pkix.Name
+	// SANs are DNS names, IP addresses or URIs such as SPIFFE IDs.
+	SANs     []string
+	Validity time.Duration
+	// ExtKeyUsage is typically x509.ExtKeyUsageServerAuth or
+	// x509.ExtKeyUsageClientAuth.
+	ExtKeyUsage []x509.ExtKeyUsage
+}
+
+// CA signs leaf certificates.
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// NewCA returns a self-signed CA for subject.
+func NewCA(subject pkix.Name, key crypto.Signer, validity time.Duration) (*CA, error) {
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("pki: could not create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("pki: could not parse CA certificate: %w", err)
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// Issue signs a certificate for the public half of key.
+func (ca *CA) Issue(t Template, key crypto.Signer) (*x509.Certificate, error) {
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               t.Subject,
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(t.Validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           t.ExtKeyUsage,
+		BasicConstraintsValid: true,
+	}
+	// TLS 1.2 RSA key exchange encrypts with the certificate key.
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if tmpl.DNSNames, tmpl.IPAddresses, tmpl.URIs, err = ParseSANs(t.SANs); err != nil {
+		return nil, err
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("pki: could not create certificate for %q: %w", t.Subject.CommonName, err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// ParseSANs sorts SANs into IP addresses, URIs (anything with a scheme) and
+// DNS names.
+func ParseSANs(sans []string) (dnsNames []string, ips []net.IP, uris []*url.URL, err error) {
+	for _, san := range sans {
+		switch {
+		case net.ParseIP(san) != nil:
+			ips = append(ips, net.ParseIP(san))
+		case strings.Contains(san, "://"):
+			u, err := url.Parse(san)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("pki: invalid URI SAN %q: %w", san, err)
+			}
+			uris = append(uris, u)
+		case san != "":
+			dnsNames = append(dnsNames, san)
+		}
+	}
+	return dnsNames, ips, uris, nil
+}
+
+// EncodeCertificate returns cert as a PEM block.
+func EncodeCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// EncodeKey returns key as a PKCS#8 PEM block, which works for every
+// algorithm.
+func EncodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("pki: could not marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ExtFile returns the openssl extension file equivalent to t issued for
+// key, as used by the manual steps in certs/README.md.
+func ExtFile(t Template, key crypto.Signer) string {
+	var b strings.Builder
+	b.WriteString("authorityKeyIdentifier=keyid,issuer\n")
+	b.WriteString("basicConstraints=CA:FALSE\n")
+	// As in Issue, only RSA keys are used for key encipherment.
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		b.WriteString("keyUsage = digitalSignature, keyEncipherment\n")
+	} else {
+		b.WriteString("keyUsage = digitalSignature\n")
+	}
+
+	var usages []string
+	for _, u := range t.ExtKeyUsage {
+		switch u {
+		case x509.ExtKeyUsageServerAuth:
+			usages = append(usages, "serverAuth")
+		case x509.ExtKeyUsageClientAuth:
+			usages = append(usages, "clientAuth")
+		}
+	}
+	if len(usages) > 0 {
+		fmt.Fprintf(&b, "extendedKeyUsage = %s\n", strings.Join(usages, ", "))
+	}
+
+	dnsNames, ips, uris, _ := ParseSANs(t.SANs)
+	if len(dnsNames)+len(ips)+len(uris) == 0 {
+		return b.String()
+	}
+	b.WriteString("subjectAltName = @alt_names\n\n[alt_names]\n")
+	for i, name := range dnsNames {
+		fmt.Fprintf(&b, "DNS.%d = %s\n", i+1, name)
+	}
+	for i, ip := range ips {
+		fmt.Fprintf(&b, "IP.%d = %s\n", i+1, ip)
+	}
+	for i, uri := range uris {
+		fmt.Fprintf(&b, "URI.%d = %s\n", i+1, uri)
+	}
+	return b.String()
+}
+
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("pki: could not generate serial number: %w", err)
+	}
+	return serial, nil
+}
@@ -0,0 +1,135 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keyPair issues a certificate for t and returns it as a tls.Certificate,
+// going through the PEM encoding the files are written with.
+func keyPair(t *testing.T, ca *CA, algorithm string, tmpl Template) tls.Certificate {
+	t.Helper()
+
+	key, err := GenerateKey(algorithm, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey returned error: %v", err)
+	}
+	cert, err := ca.Issue(tmpl, key)
+	if err != nil {
+		t.Fatalf("Issue returned error: %v", err)
+	}
+	keyPEM, err := EncodeKey(key)
+	if err != nil {
+		t.Fatalf("EncodeKey returned error: %v", err)
+	}
+	pair, err := tls.X509KeyPair(EncodeCertificate(cert), keyPEM)
+	if err != nil {
+		t.Fatalf("failed to load key pair: %v", err)
+	}
+	return pair
+}
+
+func TestIssue_Handshake(t *testing.T) {
+	server := Template{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		SANs:        []string{"localhost", "127.0.0.1"},
+		Validity:    time.Hour,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	client := Template{
+		Subject:     pkix.Name{CommonName: "Test Client", OrganizationalUnit: []string{"Client"}},
+		SANs:        []string{"spiffe://example.org/client"},
+		Validity:    time.Hour,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, algorithm := range []string{RSA, ECDSA, Ed25519} {
+		t.Run(algorithm, func(t *testing.T) {
+			caKey, err := GenerateKey(algorithm, 2048)
+			if err != nil {
+				t.Fatalf("GenerateKey returned error: %v", err)
+			}
+			ca, err := NewCA(pkix.Name{CommonName: "Test Root CA"}, caKey, 24*time.Hour)
+			if err != nil {
+				t.Fatalf("NewCA returned error: %v", err)
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.Cert)
+
+			cliConn, srvConn := net.Pipe()
+			defer cliConn.Close()
+			srvErr := make(chan error, 1)
+			go func() {
+				defer srvConn.Close()
+				srv := tls.Server(srvConn, &tls.Config{
+					Certificates: []tls.Certificate{keyPair(t, ca, algorithm, server)},
+					ClientAuth:   tls.RequireAndVerifyClientCert,
+					ClientCAs:    roots,
+				})
+				srvErr <- srv.Handshake()
+			}()
+
+			cli := tls.Client(cliConn, &tls.Config{
+				Certificates: []tls.Certificate{keyPair(t, ca, algorithm, client)},
+				RootCAs:      roots,
+				ServerName:   "127.0.0.1",
+			})
+			if err := cli.Handshake(); err != nil {
+				t.Fatalf("client handshake failed: %v", err)
+			}
+			if err := <-srvErr; err != nil {
+				t.Fatalf("server handshake failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestGenerateKey_Invalid(t *testing.T) {
+	if _, err := GenerateKey("dsa", 0); err == nil {
+		t.Error("expected error for an unknown algorithm")
+	}
+	if _, err := GenerateKey(RSA, 1024); err == nil {
+		t.Error("expected error for a short RSA key")
+	}
+}
+
+func TestExtFile(t *testing.T) {
+	rsaKey, err := GenerateKey(RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := GenerateKey(ECDSA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := ExtFile(Template{
+		SANs:        []string{"localhost", "server", "127.0.0.1", "spiffe://example.org/server"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, rsaKey)
+	for _, want := range []string{
+		"keyUsage = digitalSignature, keyEncipherment\n",
+		"extendedKeyUsage = serverAuth\n",
+		"subjectAltName = @alt_names\n",
+		"DNS.1 = localhost\nDNS.2 = server\n",
+		"IP.1 = 127.0.0.1\n",
+		"URI.1 = spiffe://example.org/server\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected ext file to contain %q, got:\n%s", want, got)
+		}
+	}
+
+	got = ExtFile(Template{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ecKey)
+	if strings.Contains(got, "alt_names") {
+		t.Errorf("expected no SAN section without SANs, got:\n%s", got)
+	}
+	if !strings.Contains(got, "keyUsage = digitalSignature\n") {
+		t.Errorf("expected an ECDSA ext file without keyEncipherment, got:\n%s", got)
+	}
+}
@@ -0,0 +1,223 @@
+package security
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	crlReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tls_crl_reloads_total",
+		Help: "Number of attempts to reload the certificate revocation lists from disk, by result",
+	}, []string{"result"})
+
+	revokedRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tls_revoked_certificate_rejections_total",
+		Help: "Number of handshakes rejected because a peer certificate is revoked, by CRL reason",
+	}, []string{"reason"})
+
+	crlNextUpdate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tls_crl_next_update_timestamp_seconds",
+		Help: "NextUpdate of each loaded CRL, as a Unix timestamp",
+	}, []string{"file", "issuer"})
+)
+
+// crlReasons names the RFC 5280 CRLReason codes.
+var crlReasons = map[int]string{
+	0:  "unspecified",
+	1:  "key_compromise",
+	2:  "ca_compromise",
+	3:  "affiliation_changed",
+	4:  "superseded",
+	5:  "cessation_of_operation",
+	6:  "certificate_hold",
+	8:  "remove_from_crl",
+	9:  "privilege_withdrawn",
+	10: "aa_compromise",
+}
+
+func crlReason(code int) string {
+	if name, ok := crlReasons[code]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// CRLSet holds the revocation lists loaded from a file of PEM "X509 CRL"
+// blocks (or a single DER CRL) and swaps them when the file changes.
+type CRLSet struct {
+	file string
+
+	mu   sync.RWMutex
+	crls []*crl
+	seen fileState
+	// nextUpdateLabels are the crlNextUpdate series set by the last load.
+	nextUpdateLabels []prometheus.Labels
+}
+
+type crl struct {
+	list *x509.RevocationList
+	// revoked maps serial numbers to their reason code.
+	revoked map[string]int
+}
+
+// NewCRLSet loads the CRLs in file once. It fails if the file cannot be
+// read or holds no valid CRL.
+func NewCRLSet(file string) (*CRLSet, error) {
+	c := &CRLSet{file: file}
+	c.seen = c.stat()
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload re-reads the file. On failure the previous CRLs stay in use.
+func (c *CRLSet) Reload() error {
+	c.mu.Lock()
+	c.seen = c.stat()
+	c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		crlReloadsTotal.WithLabelValues("failure").Inc()
+		log.Printf("[TLS] CRL reload failed, keeping previous revocation lists: %v", err)
+		return err
+	}
+	crlReloadsTotal.WithLabelValues("success").Inc()
+	log.Printf("[TLS] reloaded revocation lists from %s", c.file)
+	return nil
+}
+
+// Run checks the file every interval and reloads it when its size or
+// modification time changes, until ctx is cancelled. A non-positive
+// interval disables polling. While Run is active, ReloadFiles reloads the
+// file too.
+func (c *CRLSet) Run(ctx context.Context, interval time.Duration) {
+	poll(ctx, interval, c, func() bool {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return c.stat() != c.seen
+	})
+}
+
+// VerifyPeerCertificate rejects verified chains that contain a revoked
+// certificate. It is meant for tls.Config.VerifyPeerCertificate and only
+// sees chains that passed standard verification.
+func (c *CRLSet) VerifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	c.mu.RLock()
+	crls := c.crls
+	c.mu.RUnlock()
+
+	for _, chain := range verifiedChains {
+		// The last certificate is the trust anchor, which has no issuer
+		// to revoke it.
+		for i := 0; i+1 < len(chain); i++ {
+			cert, issuer := chain[i], chain[i+1]
+			if code, ok := revokedBy(crls, cert, issuer); ok {
+				reason := crlReason(code)
+				revokedRejections.WithLabelValues(reason).Inc()
+				return fmt.Errorf("security: certificate %q (serial %s) is %w: %s",
+					cert.Subject, cert.SerialNumber, errRevoked, reason)
+			}
+		}
+	}
+	return nil
+}
+
+// revokedBy reports whether a CRL signed by issuer lists cert, and why.
+func revokedBy(crls []*crl, cert, issuer *x509.Certificate) (int, bool) {
+	for _, l := range crls {
+		code, ok := l.revoked[cert.SerialNumber.String()]
+		if !ok || string(l.list.RawIssuer) != string(cert.RawIssuer) {
+			continue
+		}
+		if err := l.list.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+		return code, true
+	}
+	return 0, false
+}
+
+func (c *CRLSet) load() error {
+	data, err := os.ReadFile(c.file)
+	if err != nil {
+		return fmt.Errorf("security: could not read CRL file (%s): %w", c.file, err)
+	}
+
+	var ders [][]byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = [][]byte{data}
+	}
+
+	crls := make([]*crl, 0, len(ders))
+	var errs []error
+	for _, der := range ders {
+		list, err := x509.ParseRevocationList(der)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		l := &crl{list: list, revoked: make(map[string]int, len(list.RevokedCertificateEntries))}
+		for _, entry := range list.RevokedCertificateEntries {
+			l.revoked[entry.SerialNumber.String()] = entry.ReasonCode
+		}
+		if !list.NextUpdate.IsZero() && time.Now().After(list.NextUpdate) {
+			log.Printf("[TLS] WARNING: CRL from %q in %s was due for an update at %s",
+				list.Issuer, c.file, list.NextUpdate.Format(time.RFC3339))
+		}
+		crls = append(crls, l)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("security: could not parse CRL file (%s): %w", c.file, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.crls = crls
+	c.updateNextUpdate()
+	return nil
+}
+
+// updateNextUpdate replaces the crlNextUpdate series of the previous load.
+// c.mu must be held.
+func (c *CRLSet) updateNextUpdate() {
+	for _, labels := range c.nextUpdateLabels {
+		crlNextUpdate.Delete(labels)
+	}
+	c.nextUpdateLabels = c.nextUpdateLabels[:0]
+	for _, l := range c.crls {
+		if l.list.NextUpdate.IsZero() {
+			continue
+		}
+		labels := prometheus.Labels{"file": c.file, "issuer": l.list.Issuer.String()}
+		crlNextUpdate.With(labels).Set(float64(l.list.NextUpdate.Unix()))
+		c.nextUpdateLabels = append(c.nextUpdateLabels, labels)
+	}
+}
+
+func (c *CRLSet) stat() fileState {
+	if fi, err := os.Stat(c.file); err == nil {
+		return fileState{modTime: fi.ModTime(), size: fi.Size()}
+	}
+	return fileState{}
+}
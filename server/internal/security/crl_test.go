@@ -0,0 +1,232 @@
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/credentials"
+	"server/internal/config"
+)
+
+// crlPEM returns a CRL signed by ca that revokes the given serials with
+// the given reason codes.
+func (ca *testCA) crlPEM(t *testing.T, nextUpdate time.Time, revoked map[*big.Int]int) []byte {
+	t.Helper()
+
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: nextUpdate,
+	}
+	for serial, reason := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: time.Now().Add(-time.Minute),
+			ReasonCode:     reason,
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create CRL: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func writeCRL(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write CRL: %v", err)
+	}
+}
+
+func leafOf(t *testing.T, pair tls.Certificate) *x509.Certificate {
+	t.Helper()
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse leaf: %v", err)
+	}
+	return leaf
+}
+
+func TestCRLSet_VerifyPeerCertificate(t *testing.T) {
+	ca, otherCA := newTestCA(t, "Test CA"), newTestCA(t, "Other CA")
+	good := leafOf(t, ca.keyPair(t, "good-client"))
+	leaked := leafOf(t, ca.keyPair(t, "leaked-client"))
+	superseded := leafOf(t, ca.keyPair(t, "old-client"))
+	// Signed by another CA: must not revoke certificates of ca.
+	foreign := leafOf(t, ca.keyPair(t, "foreign-client"))
+
+	path := filepath.Join(t.TempDir(), "crl.pem")
+	data := append(ca.crlPEM(t, time.Now().Add(time.Hour), map[*big.Int]int{
+		leaked.SerialNumber:     1,
+		superseded.SerialNumber: 4,
+	}), otherCA.crlPEM(t, time.Now().Add(time.Hour), map[*big.Int]int{foreign.SerialNumber: 1})...)
+	writeCRL(t, path, data)
+
+	crls, err := NewCRLSet(path)
+	if err != nil {
+		t.Fatalf("NewCRLSet returned error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		cert       *x509.Certificate
+		wantReason string
+	}{
+		{name: "not revoked", cert: good},
+		{name: "key compromise", cert: leaked, wantReason: "key_compromise"},
+		{name: "superseded", cert: superseded, wantReason: "superseded"},
+		{name: "listed by another issuer", cert: foreign},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var before float64
+			if tc.wantReason != "" {
+				before = testutil.ToFloat64(revokedRejections.WithLabelValues(tc.wantReason))
+			}
+
+			err := crls.VerifyPeerCertificate(nil, [][]*x509.Certificate{{tc.cert, ca.cert}})
+			if (err != nil) != (tc.wantReason != "") {
+				t.Fatalf("VerifyPeerCertificate: got %v, want rejection=%v", err, tc.wantReason != "")
+			}
+			if tc.wantReason != "" {
+				if got := testutil.ToFloat64(revokedRejections.WithLabelValues(tc.wantReason)) - before; got != 1 {
+					t.Errorf("expected 1 rejection with reason %s, got %v", tc.wantReason, got)
+				}
+			}
+		})
+	}
+
+	labels := prometheus.Labels{"file": path, "issuer": "CN=Test CA"}
+	if got := testutil.ToFloat64(crlNextUpdate.With(labels)); got == 0 {
+		t.Error("expected the CRL next update time to be exported")
+	}
+}
+
+func TestCRLSet_Reload(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaked := leafOf(t, ca.keyPair(t, "leaked-client"))
+	chains := [][]*x509.Certificate{{leaked, ca.cert}}
+
+	path := filepath.Join(t.TempDir(), "crl.pem")
+	writeCRL(t, path, ca.crlPEM(t, time.Now().Add(time.Hour), nil))
+	crls, err := NewCRLSet(path)
+	if err != nil {
+		t.Fatalf("NewCRLSet returned error: %v", err)
+	}
+	if err := crls.VerifyPeerCertificate(nil, chains); err != nil {
+		t.Fatalf("expected the certificate to be accepted before revocation: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		crls.Run(ctx, 10*time.Millisecond)
+		close(stopped)
+	}()
+
+	writeCRL(t, path, ca.crlPEM(t, time.Now().Add(time.Hour), map[*big.Int]int{leaked.SerialNumber: 1}))
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("failed to touch %s: %v", path, err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for crls.VerifyPeerCertificate(nil, chains) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("CRL was not reloaded by Run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken file keeps the previous lists. Stop Run first so that only
+	// the explicit Reload sees the broken file.
+	cancel()
+	<-stopped
+	failures := testutil.ToFloat64(crlReloadsTotal.WithLabelValues("failure"))
+	writeCRL(t, path, []byte("not a CRL"))
+	if err := crls.Reload(); err == nil {
+		t.Error("expected Reload to fail for an invalid file")
+	}
+	if err := crls.VerifyPeerCertificate(nil, chains); err == nil {
+		t.Error("expected the previous CRL to stay in use after a failed reload")
+	}
+	if got := testutil.ToFloat64(crlReloadsTotal.WithLabelValues("failure")) - failures; got != 1 {
+		t.Errorf("expected 1 failed reload, got %v", got)
+	}
+}
+
+func TestTransportCredentials_CRL(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "server")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	good, leaked := ca.keyPair(t, "good-client"), ca.keyPair(t, "leaked-client")
+	crlFile := filepath.Join(t.TempDir(), "crl.pem")
+	writeCRL(t, crlFile, ca.crlPEM(t, time.Now().Add(time.Hour), map[*big.Int]int{leafOf(t, leaked).SerialNumber: 1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	creds, err := TransportCredentials(ctx, &config.Config{
+		TransportMode: config.TransportMTLS,
+		TLSCertFile:   files.cert,
+		TLSKeyFile:    files.key,
+		TLSCAFile:     files.ca,
+		TLSCRLFile:    crlFile,
+	})
+	if err != nil {
+		t.Fatalf("TransportCredentials returned error: %v", err)
+	}
+	addr := startTransportServer(t, creds)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(cert tls.Certificate) credentials.TransportCredentials {
+		return credentials.NewTLS(&tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{cert}})
+	}
+	if err := checkHealth(addr, client(good)); err != nil {
+		t.Errorf("expected a client that is not revoked to be accepted: %v", err)
+	}
+	if err := checkHealth(addr, client(leaked)); err == nil {
+		t.Error("expected a revoked client to be rejected")
+	}
+}
+
+func TestTransportCredentials_SPIFFECRL(t *testing.T) {
+	ca := newTestCA(t, "example.org CA")
+	good := ca.svidKeyPair(t, "spiffe://example.org/ns/prod/sa/prober")
+	leaked := ca.svidKeyPair(t, "spiffe://example.org/ns/prod/sa/leaked")
+	crlFile := filepath.Join(t.TempDir(), "crl.pem")
+	writeCRL(t, crlFile, ca.crlPEM(t, time.Now().Add(time.Hour), map[*big.Int]int{leafOf(t, leaked).SerialNumber: 1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	creds, err := TransportCredentials(ctx, &config.Config{
+		TransportMode: config.TransportMTLS,
+		SPIFFESVIDDir: writeSVIDDir(t, ca, "spiffe://example.org/ns/prod/sa/server"),
+		TLSCRLFile:    crlFile,
+	})
+	if err != nil {
+		t.Fatalf("TransportCredentials returned error: %v", err)
+	}
+	if _, err := spiffeHandshake(creds, good); err != nil {
+		t.Errorf("expected an SVID that is not revoked to be accepted: %v", err)
+	}
+	if _, err := spiffeHandshake(creds, leaked); err == nil {
+		t.Error("expected a revoked SVID to be rejected")
+	}
+}
@@ -0,0 +1,132 @@
+package security
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	certExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tls_certificate_expiry_timestamp_seconds",
+		Help: "NotAfter of the certificates loaded from disk as a Unix timestamp, by file, role (leaf or ca), subject and SANs",
+	}, []string{"file", "role", "subject", "sans"})
+	peerCertExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tls_peer_certificate_expiry_timestamp_seconds",
+		Help: "NotAfter of client certificates presented during handshakes as a Unix timestamp, by subject and SANs",
+	}, []string{"subject", "sans"})
+	peerCertLastSeen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tls_peer_certificate_last_seen_timestamp_seconds",
+		Help: "Last time a client certificate was presented during a handshake, as a Unix timestamp",
+	}, []string{"subject", "sans"})
+)
+
+// certLabels returns the label values identifying cert.
+func certLabels(cert *x509.Certificate) (subject, sans string) {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	return cert.Subject.String(), strings.Join(names, ",")
+}
+
+// peerCertTTL is how long the series of a client certificate that is no
+// longer presented are kept.
+const peerCertTTL = 24 * time.Hour
+
+// peerCerts collects peerCertExpiry and peerCertLastSeen.
+var peerCerts = &peerCertSeries{lastSeen: make(map[[2]string]time.Time)}
+
+// peerCertSeries deletes the series of client certificates that have not
+// been seen within peerCertTTL, whenever the series are collected or a
+// certificate is observed, so that they do not pile up as clients rotate
+// their certificates.
+type peerCertSeries struct {
+	mu       sync.Mutex
+	lastSeen map[[2]string]time.Time
+}
+
+func (p *peerCertSeries) observe(cert *x509.Certificate, now time.Time) {
+	subject, sans := certLabels(cert)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expireLocked(now)
+	p.lastSeen[[2]string{subject, sans}] = now
+	peerCertExpiry.WithLabelValues(subject, sans).Set(float64(cert.NotAfter.Unix()))
+	peerCertLastSeen.WithLabelValues(subject, sans).Set(float64(now.Unix()))
+}
+
+func (p *peerCertSeries) expireLocked(now time.Time) {
+	for key, seen := range p.lastSeen {
+		if now.Sub(seen) > peerCertTTL {
+			delete(p.lastSeen, key)
+			peerCertExpiry.DeleteLabelValues(key[0], key[1])
+			peerCertLastSeen.DeleteLabelValues(key[0], key[1])
+		}
+	}
+}
+
+func (p *peerCertSeries) Describe(ch chan<- *prometheus.Desc) {
+	peerCertExpiry.Describe(ch)
+	peerCertLastSeen.Describe(ch)
+}
+
+func (p *peerCertSeries) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	p.expireLocked(time.Now())
+	p.mu.Unlock()
+	peerCertExpiry.Collect(ch)
+	peerCertLastSeen.Collect(ch)
+}
+
+// observePeerCertificate records the expiry of the client certificate
+// chain[0] if a CA in roots issued it. The certificate is checked as of
+// its own NotAfter, so an expired one still shows up; one that no trusted
+// CA signed is ignored, so that unauthenticated peers cannot add series.
+func observePeerCertificate(chain []*x509.Certificate, roots *x509.CertPool) {
+	if len(chain) == 0 || roots == nil {
+		return
+	}
+	cert := chain[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   cert.NotAfter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return
+	}
+	peerCerts.observe(cert, time.Now())
+}
+
+// parseCertificates returns every certificate in a PEM bundle, skipping
+// blocks that do not parse.
+func parseCertificates(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
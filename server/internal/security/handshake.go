@@ -0,0 +1,229 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/stats"
+
+	"server/internal/logging"
+)
+
+// Handshake failure reasons, used as the reason label and span attribute.
+const (
+	reasonExpired         = "expired_certificate"
+	reasonUnknownCA       = "unknown_ca"
+	reasonBadSAN          = "bad_san"
+	reasonProtocolVersion = "protocol_version"
+	reasonNoClientCert    = "client_certificate_missing"
+	reasonRevoked         = "revoked"
+	// reasonRejectedByPeer is a certificate alert sent by the other side
+	// that names none of the reasons above.
+	reasonRejectedByPeer = "rejected_by_peer"
+	reasonTimeout        = "timeout"
+	reasonOther          = "other"
+)
+
+var (
+	handshakeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tls_handshake_duration_seconds",
+		Help:    "Duration of server TLS handshakes, by result (success or failure)",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"result"})
+
+	handshakeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tls_handshake_failures_total",
+		Help: "Number of failed server TLS handshakes, by reason",
+	}, []string{"reason"})
+)
+
+var tracer = otel.Tracer("server/internal/security")
+
+// errRevoked is wrapped by the errors of CRLSet.VerifyPeerCertificate.
+var errRevoked = errors.New("revoked")
+
+// classifyHandshakeError maps a handshake error to one of the reason
+// constants. Errors from local verification carry their x509 type; errors
+// reported by the peer only arrive as a TLS alert.
+func classifyHandshakeError(err error) string {
+	var (
+		invalid   x509.CertificateInvalidError
+		unknownCA x509.UnknownAuthorityError
+		hostname  x509.HostnameError
+		opErr     *net.OpError
+	)
+	switch {
+	case errors.Is(err, errRevoked):
+		return reasonRevoked
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return reasonExpired
+	case errors.As(err, &unknownCA):
+		return reasonUnknownCA
+	case errors.As(err, &hostname):
+		return reasonBadSAN
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return reasonTimeout
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		return classifyAlert(opErr.Err.Error())
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "didn't provide a certificate"):
+		return reasonNoClientCert
+	case strings.Contains(msg, "unsupported versions"),
+		strings.Contains(msg, "unsupported protocol version"),
+		strings.Contains(msg, "no supported versions"):
+		return reasonProtocolVersion
+	}
+	return reasonOther
+}
+
+// classifyAlert maps the text of a TLS alert received from the peer.
+// crypto/tls does not export the alert type, only its message.
+func classifyAlert(alert string) string {
+	switch alert {
+	case "tls: expired certificate":
+		return reasonExpired
+	case "tls: unknown certificate authority":
+		return reasonUnknownCA
+	case "tls: protocol version not supported":
+		return reasonProtocolVersion
+	case "tls: certificate required":
+		return reasonNoClientCert
+	case "tls: revoked certificate":
+		return reasonRevoked
+	case "tls: bad certificate", "tls: unsupported certificate", "tls: unknown certificate":
+		return reasonRejectedByPeer
+	}
+	return reasonOther
+}
+
+// handshakeObserver times one handshake and records its outcome as
+// metrics and as an event on its own span.
+type handshakeObserver struct {
+	start time.Time
+	span  trace.Span
+	// peer names the other side in debug logs.
+	peer string
+}
+
+// startHandshake starts a root span: no RPC exists yet. LinkHandshakes
+// links the first traced RPC of the connection back to it.
+func startHandshake(rawConn net.Conn) *handshakeObserver {
+	_, span := tracer.Start(context.Background(), "tls.handshake",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attribute.String("network.peer.address", rawConn.RemoteAddr().String())))
+	return &handshakeObserver{start: time.Now(), span: span, peer: rawConn.RemoteAddr().String()}
+}
+
+func (o *handshakeObserver) done(state *tls.ConnectionState, err error) {
+	defer o.span.End()
+	elapsed := time.Since(o.start).Seconds()
+
+	if err != nil {
+		reason := classifyHandshakeError(err)
+		handshakeDuration.WithLabelValues("failure").Observe(elapsed)
+		handshakeFailures.WithLabelValues(reason).Inc()
+		o.span.AddEvent("tls.handshake.failed", trace.WithAttributes(
+			attribute.String("tls.failure.reason", reason),
+			attribute.String("error.message", err.Error()),
+		))
+		o.span.SetStatus(otelcodes.Error, reason)
+		logging.Debugf("[TLS] handshake with %s failed (%s): %v", o.peer, reason, err)
+		return
+	}
+
+	handshakeDuration.WithLabelValues("success").Observe(elapsed)
+	attrs := []attribute.KeyValue{
+		attribute.String("tls.protocol.version", tls.VersionName(state.Version)),
+		attribute.String("tls.cipher", tls.CipherSuiteName(state.CipherSuite)),
+		attribute.Bool("tls.resumed", state.DidResume),
+	}
+	if len(state.PeerCertificates) > 0 {
+		attrs = append(attrs, attribute.String("tls.client.subject", state.PeerCertificates[0].Subject.String()))
+	}
+	o.span.AddEvent("tls.handshake.completed", trace.WithAttributes(attrs...))
+	logging.Debugf("[TLS] handshake with %s completed: %s, %s", o.peer,
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+}
+
+// pendingHandshakeTTL bounds how long the span of a handshake waits for
+// gRPC to take over its connection, which fails if the client never sends
+// the HTTP/2 preface.
+const pendingHandshakeTTL = 2 * time.Minute
+
+// pendingHandshakes holds the span of each completed handshake until
+// LinkHandshakes sees its connection, keyed by connKey.
+var pendingHandshakes sync.Map
+
+func connKey(local, remote net.Addr) string {
+	return local.String() + "<-" + remote.String()
+}
+
+// connHandshake is the handshake of one connection.
+type connHandshake struct {
+	span   trace.SpanContext
+	linked atomic.Bool
+}
+
+// rememberHandshake keeps the span of the handshake of rawConn for
+// LinkHandshakes. Spans that are not sampled are not worth a link.
+func rememberHandshake(rawConn net.Conn, span trace.SpanContext) {
+	if !span.IsSampled() {
+		return
+	}
+	key := connKey(rawConn.LocalAddr(), rawConn.RemoteAddr())
+	h := &connHandshake{span: span}
+	pendingHandshakes.Store(key, h)
+	time.AfterFunc(pendingHandshakeTTL, func() { pendingHandshakes.CompareAndDelete(key, h) })
+}
+
+type connHandshakeKey struct{}
+
+// LinkHandshakes returns a stats handler that links the span of the first
+// traced RPC on each connection to the span of its TLS handshake. Install
+// it after the otelgrpc handler, which starts the RPC spans.
+func LinkHandshakes() stats.Handler {
+	return handshakeLinker{}
+}
+
+type handshakeLinker struct{}
+
+func (handshakeLinker) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	if info.LocalAddr == nil || info.RemoteAddr == nil {
+		return ctx
+	}
+	if h, ok := pendingHandshakes.LoadAndDelete(connKey(info.LocalAddr, info.RemoteAddr)); ok {
+		return context.WithValue(ctx, connHandshakeKey{}, h)
+	}
+	return ctx
+}
+
+func (handshakeLinker) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	h, ok := ctx.Value(connHandshakeKey{}).(*connHandshake)
+	if !ok {
+		return ctx
+	}
+	if span := trace.SpanFromContext(ctx); span.IsRecording() && h.linked.CompareAndSwap(false, true) {
+		span.AddLink(trace.Link{SpanContext: h.span})
+	}
+	return ctx
+}
+
+func (handshakeLinker) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (handshakeLinker) HandleConn(context.Context, stats.ConnStats) {}
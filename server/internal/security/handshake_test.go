@@ -0,0 +1,284 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// serverHandshake runs creds.ServerHandshake against a client using
+// clientConfig and returns the server side error.
+func serverHandshake(creds credentials.TransportCredentials, clientConfig *tls.Config) error {
+	cliConn, srvConn := net.Pipe()
+	defer cliConn.Close()
+	srvErr := make(chan error, 1)
+	go func() {
+		_, _, err := creds.ServerHandshake(srvConn)
+		srvErr <- err
+		srvConn.Close()
+	}()
+
+	client := tls.Client(cliConn, clientConfig)
+	if err := client.Handshake(); err == nil {
+		// TLS 1.3 servers verify the client certificate after the client
+		// considers the handshake done; wait for the verdict.
+		_ = client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _ = client.Read(make([]byte, 1))
+	}
+	// Unblock the server if the client gave up first.
+	cliConn.Close()
+	return <-srvErr
+}
+
+// handshakeCount returns the number of handshakes timed with result.
+func handshakeCount(t *testing.T, result string) uint64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := handshakeDuration.WithLabelValues(result).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatalf("failed to read handshake histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// handshakeSpans records the spans of this package. The package tracer
+// keeps delegating to the first provider passed to otel.SetTracerProvider,
+// so it is installed once for every run of the tests.
+var handshakeSpans = sync.OnceValue(func() *tracetest.SpanRecorder {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	return spans
+})
+
+func TestServerCredentials_HandshakeMetrics(t *testing.T) {
+	spans := handshakeSpans()
+
+	ca, otherCA := newTestCA(t, "Test CA"), newTestCA(t, "Other CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "server")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+
+	revoked := ca.keyPair(t, "revoked-client")
+	crlFile := filepath.Join(t.TempDir(), "crl.pem")
+	writeCRL(t, crlFile, ca.crlPEM(t, time.Now().Add(time.Hour), map[*big.Int]int{leafOf(t, revoked).SerialNumber: 1}))
+	crls, err := NewCRLSet(crlFile)
+	if err != nil {
+		t.Fatalf("NewCRLSet returned error: %v", err)
+	}
+	creds := ServerCredentials(r, WithCRLs(crls))
+
+	expiredPEM, expiredKeyPEM := ca.issueUntil(t, "expired-client", time.Now().Add(-time.Hour))
+	expired, err := tls.X509KeyPair(expiredPEM, expiredKeyPEM)
+	if err != nil {
+		t.Fatalf("failed to build key pair: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	// GetClientCertificate sends cert even if the server does not list its
+	// issuer as acceptable.
+	clientConfig := func(cert tls.Certificate) *tls.Config {
+		return &tls.Config{
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &cert, nil },
+			RootCAs:              roots,
+			ServerName:           "localhost",
+			NextProtos:           []string{"h2"},
+		}
+	}
+	oldTLS := clientConfig(ca.keyPair(t, "old-client"))
+	oldTLS.MinVersion, oldTLS.MaxVersion = tls.VersionTLS10, tls.VersionTLS11
+
+	tests := []struct {
+		name       string
+		client     *tls.Config
+		wantReason string
+	}{
+		{name: "success", client: clientConfig(ca.keyPair(t, "client"))},
+		{name: "expired", client: clientConfig(expired), wantReason: reasonExpired},
+		{name: "unknown CA", client: clientConfig(otherCA.keyPair(t, "client")), wantReason: reasonUnknownCA},
+		{name: "no client certificate", client: clientConfig(tls.Certificate{}), wantReason: reasonNoClientCert},
+		{name: "protocol version", client: oldTLS, wantReason: reasonProtocolVersion},
+		{name: "revoked", client: clientConfig(revoked), wantReason: reasonRevoked},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := "success"
+			if tc.wantReason != "" {
+				result = "failure"
+			}
+			timed := handshakeCount(t, result)
+			failures := testutil.ToFloat64(handshakeFailures.WithLabelValues(tc.wantReason))
+			spans.Reset()
+
+			err := serverHandshake(creds, tc.client)
+			if (err != nil) != (tc.wantReason != "") {
+				t.Fatalf("ServerHandshake: got %v, want failure=%v", err, tc.wantReason != "")
+			}
+
+			if got := handshakeCount(t, result) - timed; got != 1 {
+				t.Errorf("expected 1 timed %s handshake, got %d", result, got)
+			}
+			if tc.wantReason != "" {
+				if got := testutil.ToFloat64(handshakeFailures.WithLabelValues(tc.wantReason)) - failures; got != 1 {
+					t.Errorf("expected 1 failure with reason %s, got %v", tc.wantReason, got)
+				}
+			}
+
+			ended := spans.Ended()
+			if len(ended) != 1 || len(ended[0].Events()) != 1 {
+				t.Fatalf("expected one handshake span with one event, got %d spans", len(ended))
+			}
+			if kind := ended[0].SpanKind(); kind != trace.SpanKindInternal {
+				t.Errorf("span kind: got %v, want %v", kind, trace.SpanKindInternal)
+			}
+			event := ended[0].Events()[0]
+			wantEvent := "tls.handshake.completed"
+			if tc.wantReason != "" {
+				wantEvent = "tls.handshake.failed"
+			}
+			if event.Name != wantEvent {
+				t.Errorf("span event: got %q, want %q", event.Name, wantEvent)
+			}
+			for _, attr := range event.Attributes {
+				if attr.Key == "tls.failure.reason" && attr.Value.AsString() != tc.wantReason {
+					t.Errorf("span reason: got %q, want %q", attr.Value.AsString(), tc.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestLinkHandshakes(t *testing.T) {
+	spans := handshakeSpans()
+	spans.Reset()
+
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "server")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer(
+		grpc.Creds(ServerCredentials(r)),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.StatsHandler(LinkHandshakes()),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{ca.keyPair(t, "client")},
+		RootCAs:      roots,
+		ServerName:   "localhost",
+	})))
+	if err != nil {
+		t.Fatalf("grpc.NewClient returned error: %v", err)
+	}
+	defer conn.Close()
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		cancel()
+		if err != nil {
+			t.Fatalf("Check returned error: %v", err)
+		}
+	}
+
+	var handshake trace.SpanContext
+	var rpcs []sdktrace.ReadOnlySpan
+	for _, span := range spans.Ended() {
+		switch span.SpanKind() {
+		case trace.SpanKindInternal:
+			handshake = span.SpanContext()
+		case trace.SpanKindServer:
+			rpcs = append(rpcs, span)
+		}
+	}
+	if !handshake.IsValid() || len(rpcs) != 2 {
+		t.Fatalf("expected a handshake span and 2 RPC spans, got handshake=%v and %d RPC spans", handshake.IsValid(), len(rpcs))
+	}
+	if links := rpcs[0].Links(); len(links) != 1 || !links[0].SpanContext.Equal(handshake) {
+		t.Errorf("expected the first RPC to link to the handshake span, got links %v", links)
+	}
+	if links := rpcs[1].Links(); len(links) != 0 {
+		t.Errorf("expected no link on the second RPC, got %v", links)
+	}
+}
+
+func TestClassifyHandshakeError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "bad SAN",
+			err:  &tls.CertificateVerificationError{Err: x509.HostnameError{Certificate: &x509.Certificate{}, Host: "example.com"}},
+			want: reasonBadSAN,
+		},
+		{
+			name: "expired",
+			err:  &tls.CertificateVerificationError{Err: x509.CertificateInvalidError{Reason: x509.Expired}},
+			want: reasonExpired,
+		},
+		{
+			name: "other invalid certificate",
+			err:  x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign},
+			want: reasonOther,
+		},
+		{
+			name: "timeout",
+			err:  &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded},
+			want: reasonTimeout,
+		},
+		{name: "peer alert", err: &net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}, want: reasonRejectedByPeer},
+		{name: "peer certificate required", err: &net.OpError{Op: "remote error", Err: errors.New("tls: certificate required")}, want: reasonNoClientCert},
+		{name: "revoked", err: fmt.Errorf("security: certificate is %w", errRevoked), want: reasonRevoked},
+		{name: "unknown", err: errors.New("EOF"), want: reasonOther},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := classifyHandshakeError(tc.err); got != tc.want {
+				t.Errorf("classifyHandshakeError(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
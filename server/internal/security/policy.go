@@ -0,0 +1,185 @@
+package security
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"server/internal/config"
+)
+
+// tlsVersions are the values accepted in TLS_MIN_VERSION and TLS_MAX_VERSION.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves are the values accepted in TLS_CURVES, matched case-insensitively.
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+	"P-256":          tls.CurveP256,
+	"P-384":          tls.CurveP384,
+	"P-521":          tls.CurveP521,
+}
+
+// Policy is a validated set of TLS protocol settings.
+type Policy struct {
+	MinVersion uint16
+	MaxVersion uint16
+	// CipherSuites, CurvePreferences and NextProtos are nil to keep the
+	// defaults.
+	CipherSuites     []uint16
+	CurvePreferences []tls.CurveID
+	NextProtos       []string
+}
+
+// ParsePolicy validates the TLS_POLICY, TLS_MIN_VERSION, TLS_MAX_VERSION,
+// TLS_CIPHER_SUITES, TLS_CURVES and TLS_ALPN settings of cfg and reports
+// every problem at once.
+func ParsePolicy(cfg *config.Config) (*Policy, error) {
+	p := &Policy{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS13}
+	var errs []error
+
+	strict := false
+	switch cfg.TLSPolicy {
+	case config.TLSPolicyDefault, "":
+	case config.TLSPolicyStrict:
+		strict = true
+		p.MinVersion = tls.VersionTLS13
+	default:
+		errs = append(errs, fmt.Errorf("TLS_POLICY=%q: must be %s or %s",
+			cfg.TLSPolicy, config.TLSPolicyDefault, config.TLSPolicyStrict))
+	}
+
+	if cfg.TLSMinVersion != "" {
+		if v, ok := tlsVersions[cfg.TLSMinVersion]; !ok {
+			errs = append(errs, fmt.Errorf("TLS_MIN_VERSION=%q: must be 1.2 or 1.3", cfg.TLSMinVersion))
+		} else if strict && v != tls.VersionTLS13 {
+			errs = append(errs, fmt.Errorf("TLS_MIN_VERSION=%q: TLS_POLICY=%s allows TLS 1.3 only",
+				cfg.TLSMinVersion, config.TLSPolicyStrict))
+		} else {
+			p.MinVersion = v
+		}
+	}
+	if cfg.TLSMaxVersion != "" {
+		if v, ok := tlsVersions[cfg.TLSMaxVersion]; !ok {
+			errs = append(errs, fmt.Errorf("TLS_MAX_VERSION=%q: must be 1.2 or 1.3", cfg.TLSMaxVersion))
+		} else {
+			p.MaxVersion = v
+		}
+	}
+	if p.MinVersion > p.MaxVersion {
+		errs = append(errs, fmt.Errorf("TLS minimum version %s is above the maximum %s",
+			tls.VersionName(p.MinVersion), tls.VersionName(p.MaxVersion)))
+	}
+
+	if len(cfg.TLSCipherSuites) > 0 {
+		if p.MinVersion == tls.VersionTLS13 {
+			errs = append(errs, errors.New("TLS_CIPHER_SUITES only applies to TLS 1.2, which this policy does not allow; "+
+				"Go does not let TLS 1.3 suites be configured"))
+		}
+		for _, name := range cfg.TLSCipherSuites {
+			id, err := cipherSuite(name)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			p.CipherSuites = append(p.CipherSuites, id)
+		}
+	}
+
+	for _, name := range cfg.TLSCurves {
+		id, ok := tlsCurves[strings.ToUpper(name)]
+		if !ok {
+			errs = append(errs, fmt.Errorf("TLS_CURVES: unknown curve %q (want one of %s)",
+				name, strings.Join(sortedKeys(tlsCurves), ", ")))
+			continue
+		}
+		p.CurvePreferences = append(p.CurvePreferences, id)
+	}
+
+	if len(cfg.TLSALPN) > 0 {
+		if !slices.Contains(cfg.TLSALPN, "h2") {
+			errs = append(errs, fmt.Errorf("TLS_ALPN=%q: must include h2, which gRPC requires",
+				strings.Join(cfg.TLSALPN, ",")))
+		}
+		p.NextProtos = cfg.TLSALPN
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("security: invalid TLS policy:\n%w", err)
+	}
+	return p, nil
+}
+
+// cipherSuite looks up a TLS 1.2 suite by its IANA name.
+func cipherSuite(name string) (uint16, error) {
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return 0, fmt.Errorf("TLS_CIPHER_SUITES: %s is insecure", name)
+		}
+	}
+	for _, s := range tls.CipherSuites() {
+		if s.Name != name {
+			continue
+		}
+		if !slices.Contains(s.SupportedVersions, tls.VersionTLS12) {
+			return 0, fmt.Errorf("TLS_CIPHER_SUITES: %s is a TLS 1.3 suite, which Go does not let be configured", name)
+		}
+		return s.ID, nil
+	}
+	return 0, fmt.Errorf("TLS_CIPHER_SUITES: unknown cipher suite %q", name)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// String describes p for the startup log.
+func (p *Policy) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s to %s", tls.VersionName(p.MinVersion), tls.VersionName(p.MaxVersion))
+	if len(p.CipherSuites) > 0 {
+		names := make([]string, len(p.CipherSuites))
+		for i, id := range p.CipherSuites {
+			names[i] = tls.CipherSuiteName(id)
+		}
+		fmt.Fprintf(&b, ", suites %s", strings.Join(names, ","))
+	}
+	if len(p.CurvePreferences) > 0 {
+		names := make([]string, len(p.CurvePreferences))
+		for i, id := range p.CurvePreferences {
+			names[i] = id.String()
+		}
+		fmt.Fprintf(&b, ", curves %s", strings.Join(names, ","))
+	}
+	if len(p.NextProtos) > 0 {
+		fmt.Fprintf(&b, ", ALPN %s", strings.Join(p.NextProtos, ","))
+	}
+	return b.String()
+}
+
+// WithPolicy applies p. Fields p leaves nil keep their value.
+func WithPolicy(p *Policy) Option {
+	return func(c *tls.Config) {
+		c.MinVersion = p.MinVersion
+		c.MaxVersion = p.MaxVersion
+		if p.CipherSuites != nil {
+			c.CipherSuites = p.CipherSuites
+		}
+		if p.CurvePreferences != nil {
+			c.CurvePreferences = p.CurvePreferences
+		}
+		if p.NextProtos != nil {
+			c.NextProtos = p.NextProtos
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var reloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "tls_certificate_reloads_total",
+	Help: "Number of attempts to reload the TLS key pair and CA bundle from disk, by result",
+}, []string{"result"})
+
+// RegisterMetrics registers the security metrics with reg.
+func RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(reloadsTotal, certExpiry, peerCerts,
+		crlReloadsTotal, revokedRejections, crlNextUpdate,
+		handshakeDuration, handshakeFailures)
+}
+
+// Reloader holds the server key pair and client CA pool loaded from disk
+// and swaps them when the files change, so rotated certificates are picked
+// up by new handshakes without a restart.
+type Reloader struct {
+	certFile, keyFile, caFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+	cas  []*x509.Certificate
+	// seen is the state of the files at the last reload attempt.
+	seen [3]fileState
+	// expiryLabels are the certExpiry series set by the last load.
+	expiryLabels []prometheus.Labels
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader loads the key pair and CA bundle once. It fails if either
+// cannot be loaded. An empty certFile or caFile leaves out the key pair or
+// the CA bundle, for transport modes that do not use them.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	r.seen = r.stat()
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Certificate returns the current key pair, or nil if there is none.
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// CAPool returns the current CA pool, or nil if there is none.
+func (r *Reloader) CAPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// CACertificates returns the certificates in the current CA bundle.
+func (r *Reloader) CACertificates() []*x509.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cas
+}
+
+// Reload re-reads the files. On failure the previous key pair and CA pool
+// stay in use.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	r.seen = r.stat()
+	r.mu.Unlock()
+
+	if err := r.load(); err != nil {
+		reloadsTotal.WithLabelValues("failure").Inc()
+		log.Printf("[TLS] reload failed, keeping previous certificates: %v", err)
+		return err
+	}
+	reloadsTotal.WithLabelValues("success").Inc()
+	log.Printf("[TLS] reloaded certificates from %s, %s, %s", r.certFile, r.keyFile, r.caFile)
+	return nil
+}
+
+// Run checks the files every interval and reloads them when their size or
+// modification time changes, until ctx is cancelled. A non-positive
+// interval disables polling. While Run is active, ReloadFiles reloads the
+// files too.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	poll(ctx, interval, r, func() bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.stat() != r.seen
+	})
+}
+
+func (r *Reloader) load() error {
+	var pair *tls.Certificate
+	if r.certFile != "" {
+		p, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("security: could not load server key pair (%s, %s): %w",
+				r.certFile, r.keyFile, err)
+		}
+		pair = &p
+	}
+
+	var pool *x509.CertPool
+	var cas []*x509.Certificate
+	if r.caFile != "" {
+		caPem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("security: could not read CA certificate file (%s): %w",
+				r.caFile, err)
+		}
+		pool = x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(caPem); !ok {
+			return fmt.Errorf("security: failed to append CA certificate(s) from %s",
+				r.caFile)
+		}
+		cas = parseCertificates(caPem)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = pair
+	r.pool = pool
+	r.cas = cas
+	var leaf *x509.Certificate
+	if pair != nil {
+		leaf = pair.Leaf
+	}
+	r.updateExpiry(leaf, cas)
+	return nil
+}
+
+// updateExpiry replaces the certExpiry series of the previous load with
+// those of leaf and cas. r.mu must be held.
+func (r *Reloader) updateExpiry(leaf *x509.Certificate, cas []*x509.Certificate) {
+	for _, labels := range r.expiryLabels {
+		certExpiry.Delete(labels)
+	}
+	r.expiryLabels = r.expiryLabels[:0]
+
+	set := func(file, role string, cert *x509.Certificate) {
+		subject, sans := certLabels(cert)
+		labels := prometheus.Labels{"file": file, "role": role, "subject": subject, "sans": sans}
+		certExpiry.With(labels).Set(float64(cert.NotAfter.Unix()))
+		r.expiryLabels = append(r.expiryLabels, labels)
+	}
+	if leaf != nil {
+		set(r.certFile, "leaf", leaf)
+	}
+	for _, ca := range cas {
+		set(r.caFile, "ca", ca)
+	}
+}
+
+// stat returns the state of the cert, key and CA files; files that cannot
+// be read have a zero state.
+func (r *Reloader) stat() [3]fileState {
+	var states [3]fileState
+	for i, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if fi, err := os.Stat(path); err == nil {
+			states[i] = fileState{modTime: fi.ModTime(), size: fi.Size()}
+		}
+	}
+	return states
+}
+
+// fileSource is a Reloader or CRLSet.
+type fileSource interface {
+	Reload() error
+}
+
+// sources are the file sources whose Run is active.
+var (
+	sourcesMu sync.Mutex
+	sources   = make(map[fileSource]struct{})
+)
+
+// poll calls src.Reload every interval when changed reports true, until
+// ctx is cancelled, and registers src with ReloadFiles meanwhile.
+func poll(ctx context.Context, interval time.Duration, src fileSource, changed func() bool) {
+	sourcesMu.Lock()
+	sources[src] = struct{}{}
+	sourcesMu.Unlock()
+	defer func() {
+		sourcesMu.Lock()
+		delete(sources, src)
+		sourcesMu.Unlock()
+	}()
+
+	// A nil channel never fires, which disables polling.
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			if changed() {
+				_ = src.Reload()
+			}
+		}
+	}
+}
+
+// ReloadFiles re-reads the key pairs, CA bundles and CRLs of every running
+// Reloader and CRLSet now, whether or not the files look changed, and
+// returns the reload errors. Sources that fail keep their previous
+// contents.
+func ReloadFiles() error {
+	sourcesMu.Lock()
+	srcs := make([]fileSource, 0, len(sources))
+	for src := range sources {
+		srcs = append(srcs, src)
+	}
+	sourcesMu.Unlock()
+
+	var errs []error
+	for _, src := range srcs {
+		errs = append(errs, src.Reload())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,504 @@
+package security
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testCA issues short-lived ECDSA certificates for reload tests.
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	return &testCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a leaf for localhost usable for both server and client auth.
+func (ca *testCA) issue(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	return ca.issueUntil(t, cn, time.Now().Add(12*time.Hour))
+}
+
+// issueUntil is issue with an explicit NotAfter.
+func (ca *testCA) issueUntil(t *testing.T, cn string, notAfter time.Time) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial number: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) keyPair(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issue(t, cn)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to build key pair: %v", err)
+	}
+	return pair
+}
+
+type tlsFiles struct {
+	cert, key, ca string
+}
+
+func writeTLSFiles(t *testing.T, files tlsFiles, certPEM, keyPEM, caPEM []byte) {
+	t.Helper()
+
+	for path, data := range map[string][]byte{files.cert: certPEM, files.key: keyPEM, files.ca: caPEM} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+}
+
+func newTestFiles(t *testing.T) tlsFiles {
+	t.Helper()
+
+	dir := t.TempDir()
+	return tlsFiles{
+		cert: filepath.Join(dir, "server.crt.pem"),
+		key:  filepath.Join(dir, "server.key.pem"),
+		ca:   filepath.Join(dir, "ca.crt.pem"),
+	}
+}
+
+func leafSerial(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse leaf: %v", err)
+	}
+	return leaf.SerialNumber.String()
+}
+
+func TestReloader_Reload(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "server-1")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+	first := leafSerial(t, r.Certificate())
+
+	successes := testutil.ToFloat64(reloadsTotal.WithLabelValues("success"))
+	failures := testutil.ToFloat64(reloadsTotal.WithLabelValues("failure"))
+
+	certPEM, keyPEM = ca.issue(t, "server-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	second := leafSerial(t, r.Certificate())
+	if second == first {
+		t.Error("expected a new certificate after reload")
+	}
+
+	// A key that does not match the certificate must not replace the pair.
+	_, otherKey := ca.issue(t, "server-3")
+	if err := os.WriteFile(files.key, otherKey, 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Error("expected Reload to fail for a mismatched key")
+	}
+	if got := leafSerial(t, r.Certificate()); got != second {
+		t.Error("expected the previous certificate to stay in use after a failed reload")
+	}
+
+	if got := testutil.ToFloat64(reloadsTotal.WithLabelValues("success")) - successes; got != 1 {
+		t.Errorf("expected 1 successful reload, got %v", got)
+	}
+	if got := testutil.ToFloat64(reloadsTotal.WithLabelValues("failure")) - failures; got != 1 {
+		t.Errorf("expected 1 failed reload, got %v", got)
+	}
+}
+
+func TestReloader_ExpiryMetrics(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	notAfter := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	certPEM, keyPEM := ca.issueUntil(t, "server-1", notAfter)
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+
+	leaf := prometheus.Labels{"file": files.cert, "role": "leaf", "subject": "CN=server-1", "sans": "localhost,127.0.0.1"}
+	if got := testutil.ToFloat64(certExpiry.With(leaf)); got != float64(notAfter.Unix()) {
+		t.Errorf("leaf expiry: got %v, want %v", got, notAfter.Unix())
+	}
+	caLabels := prometheus.Labels{"file": files.ca, "role": "ca", "subject": "CN=Test CA", "sans": ""}
+	if got := testutil.ToFloat64(certExpiry.With(caLabels)); got != float64(ca.cert.NotAfter.Unix()) {
+		t.Errorf("CA expiry: got %v, want %v", got, ca.cert.NotAfter.Unix())
+	}
+
+	// After a rotation only the new leaf is reported.
+	certPEM, keyPEM = ca.issue(t, "server-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if certExpiry.Delete(leaf) {
+		t.Error("expected the rotated-out leaf to be removed from the expiry metric")
+	}
+	leaf["subject"] = "CN=server-2"
+	if !certExpiry.Delete(leaf) {
+		t.Error("expected the new leaf in the expiry metric")
+	}
+}
+
+func TestReloader_Run(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "server-1")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+	first := leafSerial(t, r.Certificate())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx, 10*time.Millisecond)
+
+	// Make sure the new files do not share the old modification time.
+	certPEM, keyPEM = ca.issue(t, "server-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	later := time.Now().Add(time.Second)
+	for _, path := range []string{files.cert, files.key} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatalf("failed to touch %s: %v", path, err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for leafSerial(t, r.Certificate()) == first {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded by Run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloadFiles(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "server-1")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+	first := leafSerial(t, r.Certificate())
+
+	// Polling is disabled, so only ReloadFiles picks up the new files.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx, 0)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sourcesMu.Lock()
+		_, running := sources[r]
+		sourcesMu.Unlock()
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Run did not register the reloader")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	certPEM, keyPEM = ca.issue(t, "server-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+	// Sources left running by other tests may fail to reload; only the
+	// errors about these files count.
+	if err := ReloadFiles(); err != nil && strings.Contains(err.Error(), files.cert) {
+		t.Fatalf("ReloadFiles returned error: %v", err)
+	}
+	if leafSerial(t, r.Certificate()) == first {
+		t.Error("expected ReloadFiles to load the new certificate")
+	}
+
+	if err := os.WriteFile(files.key, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("failed to corrupt key: %v", err)
+	}
+	if err := ReloadFiles(); err == nil || !strings.Contains(err.Error(), files.key) {
+		t.Errorf("expected ReloadFiles to report the broken key pair, got: %v", err)
+	}
+}
+
+func TestServerCredentials_Rotation(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "Old CA"), newTestCA(t, "New CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := oldCA.issue(t, "server-1")
+	writeTLSFiles(t, files, certPEM, keyPEM, oldCA.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+	creds := ServerCredentials(r)
+
+	// handshake connects a client using clientCert and trusting roots, and
+	// returns the serial of the server certificate and the server-side error.
+	handshake := func(clientCert tls.Certificate, roots *x509.CertPool) (string, error) {
+		cliConn, srvConn := net.Pipe()
+		defer cliConn.Close()
+		defer srvConn.Close()
+
+		srvErr := make(chan error, 1)
+		go func() {
+			_, _, err := creds.ServerHandshake(srvConn)
+			srvErr <- err
+			srvConn.Close()
+		}()
+
+		client := tls.Client(cliConn, &tls.Config{
+			Certificates: []tls.Certificate{clientCert},
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			NextProtos:   []string{"h2"},
+		})
+		serial := ""
+		if err := client.Handshake(); err == nil {
+			serial = client.ConnectionState().PeerCertificates[0].SerialNumber.String()
+			// With TLS 1.3 the server verifies the client certificate after
+			// the client has finished; reading surfaces its verdict.
+			_ = client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			_, _ = client.Read(make([]byte, 1))
+		}
+		return serial, <-srvErr
+	}
+
+	oldRoots, newRoots := x509.NewCertPool(), x509.NewCertPool()
+	oldRoots.AddCert(oldCA.cert)
+	newRoots.AddCert(newCA.cert)
+
+	if _, err := handshake(oldCA.keyPair(t, "client"), oldRoots); err != nil {
+		t.Fatalf("handshake with the initial CA failed: %v", err)
+	}
+	if _, err := handshake(newCA.keyPair(t, "client"), oldRoots); err == nil {
+		t.Fatal("expected a client cert from an untrusted CA to be rejected")
+	}
+
+	// Rotate to the new CA and a server certificate issued by it.
+	certPEM, keyPEM = newCA.issue(t, "server-2")
+	writeTLSFiles(t, files, certPEM, keyPEM, newCA.certPEM)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+
+	serial, err := handshake(newCA.keyPair(t, "client"), newRoots)
+	if err != nil {
+		t.Fatalf("handshake after rotation failed: %v", err)
+	}
+	if want := leafSerial(t, r.Certificate()); serial != want {
+		t.Errorf("server presented certificate %s, want rotated %s", serial, want)
+	}
+	if _, err := handshake(oldCA.keyPair(t, "client"), oldRoots); err == nil {
+		t.Error("expected a client cert from the retired CA to be rejected")
+	}
+}
+
+func TestServerCredentials_PeerExpiry(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "server")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	r, err := NewReloader(files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatalf("NewReloader returned error: %v", err)
+	}
+	creds := ServerCredentials(r)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	rogue := newTestCA(t, "Rogue CA")
+
+	tests := []struct {
+		name     string
+		cn       string
+		issuer   *testCA
+		notAfter time.Time
+		wantErr  bool
+		// wantSeries is false for certificates that no trusted CA issued.
+		wantSeries bool
+	}{
+		{name: "valid", cn: "valid-client", issuer: ca, notAfter: time.Now().Add(time.Hour).Truncate(time.Second), wantSeries: true},
+		{name: "expired", cn: "expired-client", issuer: ca, notAfter: time.Now().Add(-time.Hour).Truncate(time.Second), wantErr: true, wantSeries: true},
+		{name: "unknown CA", cn: "rogue-client", issuer: rogue, notAfter: time.Now().Add(time.Hour).Truncate(time.Second), wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			certPEM, keyPEM := tc.issuer.issueUntil(t, tc.cn, tc.notAfter)
+			clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatalf("failed to build key pair: %v", err)
+			}
+
+			cliConn, srvConn := net.Pipe()
+			defer cliConn.Close()
+			srvErr := make(chan error, 1)
+			go func() {
+				_, _, err := creds.ServerHandshake(srvConn)
+				srvErr <- err
+				srvConn.Close()
+			}()
+			client := tls.Client(cliConn, &tls.Config{
+				Certificates: []tls.Certificate{clientCert},
+				RootCAs:      roots,
+				ServerName:   "localhost",
+				NextProtos:   []string{"h2"},
+			})
+			if err := client.Handshake(); err == nil {
+				_ = client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				_, _ = client.Read(make([]byte, 1))
+			}
+
+			if err := <-srvErr; (err != nil) != tc.wantErr {
+				t.Fatalf("ServerHandshake error: got %v, want error=%v", err, tc.wantErr)
+			}
+			if !tc.wantSeries {
+				if n := peerSeries(t, "CN="+tc.cn); n != 0 {
+					t.Errorf("got %d series for a certificate from an unknown CA, want none", n)
+				}
+				return
+			}
+			labels := prometheus.Labels{"subject": "CN=" + tc.cn, "sans": "localhost,127.0.0.1"}
+			if got := testutil.ToFloat64(peerCertExpiry.With(labels)); got != float64(tc.notAfter.Unix()) {
+				t.Errorf("peer expiry: got %v, want %v", got, tc.notAfter.Unix())
+			}
+			if got := testutil.ToFloat64(peerCertLastSeen.With(labels)); got == 0 {
+				t.Error("expected the peer certificate to be marked as seen")
+			}
+		})
+	}
+}
+
+// peerSeries counts the peer certificate series with the given subject.
+func peerSeries(t *testing.T, subject string) int {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(peerCerts)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather peer certificate series: %v", err)
+	}
+	var n int
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "subject" && l.GetValue() == subject {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+func TestPeerCertSeries_Expire(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	certPEM, _ := ca.issue(t, "departed-client")
+	cert := parseCertificates(certPEM)[0]
+
+	now := time.Now()
+	peerCerts.observe(cert, now.Add(-peerCertTTL+time.Minute))
+	if n := peerSeries(t, "CN=departed-client"); n != 2 {
+		t.Fatalf("got %d series for a certificate seen within the TTL, want 2", n)
+	}
+
+	peerCerts.mu.Lock()
+	peerCerts.expireLocked(now.Add(2 * time.Minute))
+	peerCerts.mu.Unlock()
+	if n := peerSeries(t, "CN=departed-client"); n != 0 {
+		t.Errorf("got %d series for a certificate not seen within the TTL, want none", n)
+	}
+}
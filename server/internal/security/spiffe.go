@@ -0,0 +1,194 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"google.golang.org/grpc/credentials"
+	"server/internal/config"
+)
+
+// Files read from SPIFFE_SVID_DIR, named as spiffe-helper writes them.
+const (
+	SVIDFileName       = "svid.pem"
+	SVIDKeyFileName    = "svid_key.pem"
+	SVIDBundleFileName = "svid_bundle.pem"
+)
+
+// workloadAPITimeout bounds the wait for the first SVID from the agent.
+const workloadAPITimeout = 30 * time.Second
+
+// LoadSPIFFECredentials returns mTLS credentials that present the server's
+// X.509 SVID, taken from the Workload API socket or the SVID directory in
+// cfg, and accept clients by SPIFFE ID: one of cfg.SPIFFEAllowedIDs, or any
+// ID in the server's own trust domain. The SVID and bundle follow rotations
+// until ctx is cancelled.
+func LoadSPIFFECredentials(ctx context.Context, cfg *config.Config, opts ...Option) (credentials.TransportCredentials, error) {
+	svids, bundles, err := newSPIFFESource(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	svid, err := svids.GetX509SVID()
+	if err != nil {
+		return nil, fmt.Errorf("security: could not get X.509 SVID: %w", err)
+	}
+	authorize, err := spiffeAuthorizer(cfg.SPIFFEAllowedIDs, svid.ID.TrustDomain())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[TLS] using SPIFFE identity %s", svid.ID)
+	return spiffeServerCredentials(svids, bundles, authorize, opts...), nil
+}
+
+// spiffeServerCredentials verifies client chains against the bundle of the
+// server's trust domain with the standard library, so VerifiedChains stays
+// available to the authz package, then checks the SVID and its ID.
+func spiffeServerCredentials(
+	svids x509svid.Source,
+	bundles x509bundle.Source,
+	authorize tlsconfig.Authorizer,
+	opts ...Option,
+) credentials.TransportCredentials {
+	tlsConfig := &tls.Config{
+		GetCertificate: tlsconfig.GetCertificate(svids),
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		NextProtos:     []string{"h2"},
+		MinVersion:     tls.VersionTLS12,
+	}
+	for _, opt := range opts {
+		opt(tlsConfig)
+	}
+	// Checks set by opts, such as WithCRLs, run after SPIFFE authentication
+	// on the chains verified against the bundle.
+	tlsConfig.VerifyPeerCertificate = tlsconfig.WrapVerifyPeerCertificate(tlsConfig.VerifyPeerCertificate, bundles, authorize)
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		svid, err := svids.GetX509SVID()
+		if err != nil {
+			return nil, err
+		}
+		bundle, err := bundles.GetX509BundleForTrustDomain(svid.ID.TrustDomain())
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		for _, ca := range bundle.X509Authorities() {
+			pool.AddCert(ca)
+		}
+
+		c := tlsConfig.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = pool
+		return c, nil
+	}
+
+	return &observedCredentials{TransportCredentials: credentials.NewTLS(tlsConfig)}
+}
+
+// newSPIFFESource connects to the Workload API or loads the SVID directory.
+// Either source is released when ctx is cancelled.
+func newSPIFFESource(ctx context.Context, cfg *config.Config) (x509svid.Source, x509bundle.Source, error) {
+	switch {
+	case cfg.SPIFFEEndpointSocket != "" && cfg.SPIFFESVIDDir != "":
+		return nil, nil, errors.New("security: set either SPIFFE_ENDPOINT_SOCKET or SPIFFE_SVID_DIR, not both")
+
+	case cfg.SPIFFEEndpointSocket != "":
+		initCtx, cancel := context.WithTimeout(ctx, workloadAPITimeout)
+		defer cancel()
+		source, err := workloadapi.NewX509Source(initCtx,
+			workloadapi.WithClientOptions(workloadapi.WithAddr(cfg.SPIFFEEndpointSocket)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("security: could not fetch X.509 SVID from the Workload API (%s): %w",
+				cfg.SPIFFEEndpointSocket, err)
+		}
+		go func() {
+			<-ctx.Done()
+			_ = source.Close()
+		}()
+		return source, source, nil
+
+	case cfg.SPIFFESVIDDir != "":
+		r, err := NewReloader(
+			filepath.Join(cfg.SPIFFESVIDDir, SVIDFileName),
+			filepath.Join(cfg.SPIFFESVIDDir, SVIDKeyFileName),
+			filepath.Join(cfg.SPIFFESVIDDir, SVIDBundleFileName),
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		source := &reloaderSource{certs: r}
+		if _, err := source.GetX509SVID(); err != nil {
+			return nil, nil, fmt.Errorf("security: %s is not an X.509 SVID: %w", r.certFile, err)
+		}
+		go r.Run(ctx, cfg.TLSReloadInterval)
+		return source, source, nil
+	}
+	return nil, nil, errors.New("security: neither SPIFFE_ENDPOINT_SOCKET nor SPIFFE_SVID_DIR is set")
+}
+
+// spiffeAuthorizer accepts the given IDs, or every member of trustDomain
+// if there are none.
+func spiffeAuthorizer(allowed []string, trustDomain spiffeid.TrustDomain) (tlsconfig.Authorizer, error) {
+	if len(allowed) == 0 {
+		return tlsconfig.AuthorizeMemberOf(trustDomain), nil
+	}
+	ids := make([]spiffeid.ID, 0, len(allowed))
+	for _, s := range allowed {
+		id, err := spiffeid.FromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("security: invalid SPIFFE ID %q: %w", s, err)
+		}
+		ids = append(ids, id)
+	}
+	return tlsconfig.AuthorizeOneOf(ids...), nil
+}
+
+// reloaderSource serves the files of an SVID directory, as kept current by
+// a Reloader, to go-spiffe. The bundle is only used for the trust domain of
+// the SVID.
+type reloaderSource struct {
+	certs *Reloader
+}
+
+func (s *reloaderSource) GetX509SVID() (*x509svid.SVID, error) {
+	pair := s.certs.Certificate()
+	chain := make([]*x509.Certificate, 0, len(pair.Certificate))
+	for _, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	id, err := x509svid.IDFromCert(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key of type %T cannot sign", pair.PrivateKey)
+	}
+	return &x509svid.SVID{ID: id, Certificates: chain, PrivateKey: signer}, nil
+}
+
+func (s *reloaderSource) GetX509BundleForTrustDomain(td spiffeid.TrustDomain) (*x509bundle.Bundle, error) {
+	svid, err := s.GetX509SVID()
+	if err != nil {
+		return nil, err
+	}
+	if td != svid.ID.TrustDomain() {
+		return nil, fmt.Errorf("no bundle for trust domain %q", td)
+	}
+	return x509bundle.FromX509Authorities(td, s.certs.CACertificates()), nil
+}
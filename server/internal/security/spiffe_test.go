@@ -0,0 +1,262 @@
+package security
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"server/internal/config"
+)
+
+// issueSVID returns an X.509 SVID for id with no DNS or IP SANs, and its
+// PKCS#8 key, as SPIRE issues them.
+func (ca *testCA) issueSVID(t *testing.T, id string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial number: %v", err)
+	}
+	uri, err := url.Parse(id)
+	if err != nil {
+		t.Fatalf("failed to parse SPIFFE ID: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{uri},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) svidKeyPair(t *testing.T, id string) tls.Certificate {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issueSVID(t, id)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to build key pair: %v", err)
+	}
+	return pair
+}
+
+// fakeWorkloadAPI hands out a single X.509 SVID over a unix socket.
+type fakeWorkloadAPI struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	svid *workload.X509SVID
+}
+
+func (f *fakeWorkloadAPI) FetchX509SVID(_ *workload.X509SVIDRequest, stream workload.SpiffeWorkloadAPI_FetchX509SVIDServer) error {
+	if err := stream.Send(&workload.X509SVIDResponse{Svids: []*workload.X509SVID{f.svid}}); err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// startWorkloadAPI serves id, issued by ca, and returns the socket address.
+func startWorkloadAPI(t *testing.T, ca *testCA, id string) string {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issueSVID(t, id)
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", socket, err)
+	}
+	srv := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(srv, &fakeWorkloadAPI{svid: &workload.X509SVID{
+		SpiffeId:    id,
+		X509Svid:    certBlock.Bytes,
+		X509SvidKey: keyBlock.Bytes,
+		Bundle:      ca.cert.Raw,
+	}})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return "unix://" + socket
+}
+
+// writeSVIDDir writes the files of an SVID directory for id.
+func writeSVIDDir(t *testing.T, ca *testCA, id string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issueSVID(t, id)
+	writeTLSFiles(t, tlsFiles{
+		cert: filepath.Join(dir, SVIDFileName),
+		key:  filepath.Join(dir, SVIDKeyFileName),
+		ca:   filepath.Join(dir, SVIDBundleFileName),
+	}, certPEM, keyPEM, ca.certPEM)
+	return dir
+}
+
+// spiffeHandshake connects a client presenting clientCert, without checking
+// the server certificate, and returns the server side of the handshake.
+func spiffeHandshake(creds credentials.TransportCredentials, clientCert tls.Certificate) (credentials.AuthInfo, error) {
+	cliConn, srvConn := net.Pipe()
+	defer cliConn.Close()
+
+	type result struct {
+		info credentials.AuthInfo
+		err  error
+	}
+	srvResult := make(chan result, 1)
+	go func() {
+		_, info, err := creds.ServerHandshake(srvConn)
+		srvResult <- result{info, err}
+		srvConn.Close()
+	}()
+
+	client := tls.Client(cliConn, &tls.Config{
+		Certificates:       []tls.Certificate{clientCert},
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2"},
+	})
+	if err := client.Handshake(); err == nil {
+		_ = client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _ = client.Read(make([]byte, 1))
+	}
+	res := <-srvResult
+	return res.info, res.err
+}
+
+func TestLoadSPIFFECredentials(t *testing.T) {
+	ca, otherCA := newTestCA(t, "example.org CA"), newTestCA(t, "Other CA")
+	const serverID = "spiffe://example.org/ns/prod/sa/server"
+
+	tests := []struct {
+		name    string
+		cfg     func(t *testing.T) *config.Config
+		client  tls.Certificate
+		wantErr bool
+	}{
+		{
+			name: "directory, allowed ID",
+			cfg: func(t *testing.T) *config.Config {
+				return &config.Config{
+					SPIFFESVIDDir:    writeSVIDDir(t, ca, serverID),
+					SPIFFEAllowedIDs: []string{"spiffe://example.org/ns/prod/sa/prober"},
+				}
+			},
+			client: ca.svidKeyPair(t, "spiffe://example.org/ns/prod/sa/prober"),
+		},
+		{
+			name: "directory, other ID",
+			cfg: func(t *testing.T) *config.Config {
+				return &config.Config{
+					SPIFFESVIDDir:    writeSVIDDir(t, ca, serverID),
+					SPIFFEAllowedIDs: []string{"spiffe://example.org/ns/prod/sa/prober"},
+				}
+			},
+			client:  ca.svidKeyPair(t, "spiffe://example.org/ns/prod/sa/billing"),
+			wantErr: true,
+		},
+		{
+			name: "directory, certificate without SPIFFE ID",
+			cfg: func(t *testing.T) *config.Config {
+				return &config.Config{SPIFFESVIDDir: writeSVIDDir(t, ca, serverID)}
+			},
+			client:  ca.keyPair(t, "client"),
+			wantErr: true,
+		},
+		{
+			name: "workload API, same trust domain",
+			cfg: func(t *testing.T) *config.Config {
+				return &config.Config{SPIFFEEndpointSocket: startWorkloadAPI(t, ca, serverID)}
+			},
+			client: ca.svidKeyPair(t, "spiffe://example.org/ns/dev/sa/anything"),
+		},
+		{
+			name: "workload API, untrusted CA",
+			cfg: func(t *testing.T) *config.Config {
+				return &config.Config{SPIFFEEndpointSocket: startWorkloadAPI(t, ca, serverID)}
+			},
+			client:  otherCA.svidKeyPair(t, "spiffe://example.org/ns/dev/sa/anything"),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			creds, err := LoadSPIFFECredentials(ctx, tc.cfg(t))
+			if err != nil {
+				t.Fatalf("LoadSPIFFECredentials returned error: %v", err)
+			}
+			info, err := spiffeHandshake(creds, tc.client)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ServerHandshake error: got %v, want error=%v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// The authz package relies on the chain having been verified.
+			if chains := info.(credentials.TLSInfo).State.VerifiedChains; len(chains) == 0 {
+				t.Error("expected verified chains for an accepted client")
+			}
+		})
+	}
+}
+
+func TestLoadSPIFFECredentials_Invalid(t *testing.T) {
+	ca := newTestCA(t, "example.org CA")
+	notSVID := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "server")
+	writeTLSFiles(t, tlsFiles{
+		cert: filepath.Join(notSVID, SVIDFileName),
+		key:  filepath.Join(notSVID, SVIDKeyFileName),
+		ca:   filepath.Join(notSVID, SVIDBundleFileName),
+	}, certPEM, keyPEM, ca.certPEM)
+
+	tests := []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{name: "both sources", cfg: &config.Config{SPIFFEEndpointSocket: "unix:///nonexistent.sock", SPIFFESVIDDir: t.TempDir()}},
+		{name: "empty directory", cfg: &config.Config{SPIFFESVIDDir: t.TempDir()}},
+		{name: "certificate without SPIFFE ID", cfg: &config.Config{SPIFFESVIDDir: notSVID}},
+		{name: "invalid allowed ID", cfg: &config.Config{SPIFFESVIDDir: writeSVIDDir(t, ca, "spiffe://example.org/server"), SPIFFEAllowedIDs: []string{"example.org/client"}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := LoadSPIFFECredentials(context.Background(), tc.cfg); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
@@ -3,39 +3,119 @@ package security
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"fmt"
-	"os"
+	"errors"
+	"net"
+	"time"
 
 	"google.golang.org/grpc/credentials"
 	"server/internal/config"
 )
 
+// Option adjusts the tls.Config of the credentials built by this package.
+type Option func(*tls.Config)
+
+// WithCRLs rejects clients whose certificate, or one of its issuers, is
+// listed in crls.
+func WithCRLs(crls *CRLSet) Option {
+	return func(c *tls.Config) {
+		c.VerifyPeerCertificate = crls.VerifyPeerCertificate
+	}
+}
+
+// LoadTLSCredentials applies the TLS policy of cfg and loads the server key
+// pair, client CA and, if configured, CRL file once. Use NewReloader,
+// NewCRLSet and ServerCredentials to pick up rotated files.
 func LoadTLSCredentials(cfg *config.Config) (credentials.TransportCredentials, error) {
-	serverPair, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	r, err := NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile)
 	if err != nil {
-		return nil, fmt.Errorf("security: could not load server key pair (%s, %s): %w",
-			cfg.TLSCertFile, cfg.TLSKeyFile, err)
+		return nil, err
 	}
-
-	caPem, err := os.ReadFile(cfg.TLSCAFile)
+	policy, err := ParsePolicy(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("security: could not read CA certificate file (%s): %w",
-			cfg.TLSCAFile, err)
+		return nil, err
 	}
-	clientCertPool := x509.NewCertPool()
-	if ok := clientCertPool.AppendCertsFromPEM(caPem); !ok {
-		return nil, fmt.Errorf("security: failed to append CA certificate(s) from %s",
-			cfg.TLSCAFile)
+	opts := []Option{WithPolicy(policy)}
+	if cfg.TLSCRLFile != "" {
+		crls, err := NewCRLSet(cfg.TLSCRLFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCRLs(crls))
 	}
+	return ServerCredentials(r, opts...), nil
+}
 
+// ServerCredentials returns mTLS credentials that read the key pair and
+// client CA pool from r on every handshake.
+func ServerCredentials(r *Reloader, opts ...Option) credentials.TransportCredentials {
+	return serverCredentials(r, tls.RequireAndVerifyClientCert, opts)
+}
+
+// ServerTLSCredentials returns credentials that present the key pair from
+// r but do not ask clients for a certificate.
+func ServerTLSCredentials(r *Reloader, opts ...Option) credentials.TransportCredentials {
+	return serverCredentials(r, tls.NoClientCert, opts)
+}
+
+func serverCredentials(r *Reloader, clientAuth tls.ClientAuthType, opts []Option) credentials.TransportCredentials {
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{serverPair},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.Certificate(), nil
+		},
 
-		ClientAuth: tls.RequireAndVerifyClientCert,
-		ClientCAs:  clientCertPool,
+		ClientAuth: clientAuth,
 
+		// gRPC requires h2; the per-handshake config below is built from
+		// this one, not from the copy credentials.NewTLS adds it to.
+		NextProtos: []string{"h2"},
 		MinVersion: tls.VersionTLS12,
 	}
+	for _, opt := range opts {
+		opt(tlsConfig)
+	}
+	// ClientCAs has no callback of its own, so each handshake gets a copy
+	// of the config with the current pool.
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := tlsConfig.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = r.CAPool()
+		return c, nil
+	}
+
+	return &observedCredentials{TransportCredentials: credentials.NewTLS(tlsConfig), caPool: r.CAPool}
+}
+
+// observedCredentials times and classifies every server handshake and
+// records the client certificate. A certificate rejected during
+// verification is only recorded if a CA from caPool issued it, such as
+// an expired one; caPool may be nil.
+type observedCredentials struct {
+	credentials.TransportCredentials
+	caPool func() *x509.CertPool
+}
+
+func (c *observedCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	handshake := startHandshake(rawConn)
+	conn, info, err := c.TransportCredentials.ServerHandshake(rawConn)
+	if err != nil {
+		handshake.done(nil, err)
+		var verr *tls.CertificateVerificationError
+		if errors.As(err, &verr) && c.caPool != nil {
+			observePeerCertificate(verr.UnverifiedCertificates, c.caPool())
+		}
+		return nil, nil, err
+	}
+	tlsInfo, _ := info.(credentials.TLSInfo)
+	handshake.done(&tlsInfo.State, nil)
+	rememberHandshake(rawConn, handshake.span.SpanContext())
+	// Every credential of this package verifies the client certificate,
+	// if it asks for one, so a completed handshake has a verified chain.
+	if len(tlsInfo.State.PeerCertificates) > 0 {
+		peerCerts.observe(tlsInfo.State.PeerCertificates[0], time.Now())
+	}
+	return conn, info, nil
+}
 
-	return credentials.NewTLS(tlsConfig), nil
+func (c *observedCredentials) Clone() credentials.TransportCredentials {
+	return &observedCredentials{TransportCredentials: c.TransportCredentials.Clone(), caPool: c.caPool}
 }
@@ -0,0 +1,81 @@
+package security
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"server/internal/config"
+)
+
+// TransportCredentials returns the server credentials for
+// cfg.TransportMode, restricted to the TLS policy of cfg. TLS files are
+// re-read every TLSReloadInterval until ctx is cancelled; SPIFFE sources
+// follow their agent.
+func TransportCredentials(ctx context.Context, cfg *config.Config) (credentials.TransportCredentials, error) {
+	if cfg.SPIFFEEnabled() && cfg.TransportMode != config.TransportMTLS {
+		return nil, fmt.Errorf("security: SPIFFE identities need TRANSPORT_MODE=%s, got %q",
+			config.TransportMTLS, cfg.TransportMode)
+	}
+	policy, err := ParsePolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TransportMode != config.TransportPlaintext {
+		log.Printf("[TLS] policy %s: %s", cmp.Or(cfg.TLSPolicy, config.TLSPolicyDefault), policy)
+	}
+
+	switch cfg.TransportMode {
+	case config.TransportPlaintext:
+		log.Println("[TLS] ******************************************************************")
+		log.Println("[TLS] WARNING: TRANSPORT_MODE=plaintext, gRPC traffic is NOT encrypted")
+		log.Println("[TLS] WARNING: and clients are NOT authenticated. Local development only.")
+		log.Println("[TLS] ******************************************************************")
+		return insecure.NewCredentials(), nil
+
+	case config.TransportTLS:
+		certs, err := NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, "")
+		if err != nil {
+			return nil, err
+		}
+		go certs.Run(ctx, cfg.TLSReloadInterval)
+		return ServerTLSCredentials(certs, WithPolicy(policy)), nil
+
+	case config.TransportMTLS:
+		if cfg.SPIFFEEnabled() {
+			opts, err := crlOptions(ctx, cfg)
+			if err != nil {
+				return nil, err
+			}
+			return LoadSPIFFECredentials(ctx, cfg, append(opts, WithPolicy(policy))...)
+		}
+		certs, err := NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		go certs.Run(ctx, cfg.TLSReloadInterval)
+		opts, err := crlOptions(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return ServerCredentials(certs, append(opts, WithPolicy(policy))...), nil
+	}
+	return nil, fmt.Errorf("security: unknown transport mode %q", cfg.TransportMode)
+}
+
+// crlOptions loads cfg.TLSCRLFile, if set, and keeps re-reading it every
+// TLSReloadInterval until ctx is cancelled.
+func crlOptions(ctx context.Context, cfg *config.Config) ([]Option, error) {
+	if cfg.TLSCRLFile == "" {
+		return nil, nil
+	}
+	crls, err := NewCRLSet(cfg.TLSCRLFile)
+	if err != nil {
+		return nil, err
+	}
+	go crls.Run(ctx, cfg.TLSReloadInterval)
+	return []Option{WithCRLs(crls)}, nil
+}
@@ -0,0 +1,124 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"server/internal/config"
+)
+
+// startTransportServer serves the health service with creds on a local port.
+func startTransportServer(t *testing.T, creds credentials.TransportCredentials) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer(grpc.Creds(creds))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func checkHealth(addr string, creds credentials.TransportCredentials) error {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestTransportCredentials(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	files := newTestFiles(t)
+	certPEM, keyPEM := ca.issue(t, "server")
+	writeTLSFiles(t, files, certPEM, keyPEM, ca.certPEM)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCert := ca.keyPair(t, "client")
+
+	// Clients by the transport they speak.
+	clients := map[string]credentials.TransportCredentials{
+		"plaintext": insecure.NewCredentials(),
+		"tls":       credentials.NewTLS(&tls.Config{RootCAs: roots, ServerName: "localhost"}),
+		"mtls": credentials.NewTLS(&tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: []tls.Certificate{clientCert},
+		}),
+	}
+
+	tests := []struct {
+		mode   string
+		client string
+		ok     bool
+	}{
+		{mode: config.TransportPlaintext, client: "plaintext", ok: true},
+		{mode: config.TransportPlaintext, client: "tls"},
+		{mode: config.TransportTLS, client: "tls", ok: true},
+		{mode: config.TransportTLS, client: "mtls", ok: true},
+		{mode: config.TransportTLS, client: "plaintext"},
+		{mode: config.TransportMTLS, client: "mtls", ok: true},
+		{mode: config.TransportMTLS, client: "tls"},
+		{mode: config.TransportMTLS, client: "plaintext"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.mode+" server/"+tc.client+" client", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			creds, err := TransportCredentials(ctx, &config.Config{
+				TransportMode: tc.mode,
+				TLSCertFile:   files.cert,
+				TLSKeyFile:    files.key,
+				TLSCAFile:     files.ca,
+			})
+			if err != nil {
+				t.Fatalf("TransportCredentials returned error: %v", err)
+			}
+			addr := startTransportServer(t, creds)
+
+			err = checkHealth(addr, clients[tc.client])
+			if (err == nil) != tc.ok {
+				t.Errorf("health check: got error %v, want success=%v", err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestTransportCredentials_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{name: "unknown mode", cfg: &config.Config{TransportMode: "ssl"}},
+		{name: "SPIFFE without mTLS", cfg: &config.Config{TransportMode: config.TransportTLS, SPIFFESVIDDir: t.TempDir()}},
+		{name: "TLS without key pair", cfg: &config.Config{TransportMode: config.TransportTLS, TLSCertFile: "/nonexistent/server.crt.pem"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := TransportCredentials(context.Background(), tc.cfg); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
@@ -2,14 +2,26 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"time"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	monitoringpb "server/internal/pb/monitoring"
 )
 
+const (
+	// DefaultWatchInterval is used when a Watch request does not set an interval.
+	DefaultWatchInterval = 5 * time.Second
+	// MinWatchInterval protects the server from clients asking for a busy loop.
+	MinWatchInterval = 100 * time.Millisecond
+)
+
 type Service struct {
 	monitoringpb.UnimplementedMonitoringServiceServer
 }
@@ -47,3 +59,67 @@ func (s *Service) Monitoring(
 		Message: responseText,
 	}, nil
 }
+
+// Watch pushes a heartbeat every interval until the client cancels the stream.
+func (s *Service) Watch(
+	req *monitoringpb.WatchRequest,
+	stream grpc.ServerStreamingServer[monitoringpb.Heartbeat],
+) error {
+	interval := DefaultWatchInterval
+	if d := req.GetInterval(); d != nil {
+		if err := d.CheckValid(); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid interval: %v", err)
+		}
+		interval = d.AsDuration()
+	}
+	if interval < MinWatchInterval {
+		return status.Errorf(codes.InvalidArgument, "interval %s is below the minimum of %s", interval, MinWatchInterval)
+	}
+
+	ctx := stream.Context()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for seq := uint64(1); ; seq++ {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case t := <-ticker.C:
+			hb := &monitoringpb.Heartbeat{
+				Sequence: seq,
+				SentAt:   timestamppb.New(t),
+			}
+			if err := stream.Send(hb); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// PingStream echoes every client message back with the times the server
+// received and sent it, so the client can split latency by direction.
+func (s *Service) PingStream(stream grpc.BidiStreamingServer[monitoringpb.Client, monitoringpb.PingStreamResponse]) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		receivedAt := timestamppb.Now()
+
+		if req.GetRequestDate() == nil {
+			return status.Errorf(codes.InvalidArgument, "request_date must not be nil")
+		}
+
+		resp := &monitoringpb.PingStreamResponse{
+			ClientRequest: req,
+			ReceivedAt:    receivedAt,
+			SentAt:        timestamppb.Now(),
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
@@ -2,11 +2,16 @@ package service
 
 import (
 	"context"
+	"net"
 	"testing"
 	"time"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	monitoringpb "server/internal/pb/monitoring"
@@ -97,3 +102,123 @@ func TestMonitoring(t *testing.T) {
 		})
 	}
 }
+
+func newBufconnClient(t *testing.T, svc *Service) monitoringpb.MonitoringServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	monitoringpb.RegisterMonitoringServiceServer(server, svc)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return monitoringpb.NewMonitoringServiceClient(conn)
+}
+
+func TestWatch(t *testing.T) {
+	client := newBufconnClient(t, NewService())
+
+	t.Run("heartbeats until cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, err := client.Watch(ctx, &monitoringpb.WatchRequest{
+			Interval: durationpb.New(MinWatchInterval),
+		})
+		if err != nil {
+			t.Fatalf("Watch returned error: %v", err)
+		}
+
+		for want := uint64(1); want <= 3; want++ {
+			hb, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Recv #%d returned error: %v", want, err)
+			}
+			if hb.GetSequence() != want {
+				t.Errorf("unexpected sequence: got %d, want %d", hb.GetSequence(), want)
+			}
+			if hb.GetSentAt() == nil {
+				t.Errorf("heartbeat #%d has no sent_at", want)
+			}
+		}
+
+		cancel()
+		if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+			t.Errorf("expected Canceled after cancel, got %v", err)
+		}
+	})
+
+	t.Run("interval below minimum", func(t *testing.T) {
+		stream, err := client.Watch(context.Background(), &monitoringpb.WatchRequest{
+			Interval: durationpb.New(time.Millisecond),
+		})
+		if err != nil {
+			t.Fatalf("Watch returned error: %v", err)
+		}
+		if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument, got %v", err)
+		}
+	})
+}
+
+func TestPingStream(t *testing.T) {
+	client := newBufconnClient(t, NewService())
+
+	t.Run("echoes with timestamps", func(t *testing.T) {
+		stream, err := client.PingStream(context.Background())
+		if err != nil {
+			t.Fatalf("PingStream returned error: %v", err)
+		}
+
+		for i := 0; i < 3; i++ {
+			sent := timestamppb.Now()
+			if err := stream.Send(&monitoringpb.Client{Message: "ping", RequestDate: sent}); err != nil {
+				t.Fatalf("Send #%d returned error: %v", i, err)
+			}
+			resp, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Recv #%d returned error: %v", i, err)
+			}
+			if !resp.GetClientRequest().GetRequestDate().AsTime().Equal(sent.AsTime()) {
+				t.Errorf("echoed request_date %v, want %v", resp.GetClientRequest().GetRequestDate().AsTime(), sent.AsTime())
+			}
+			receivedAt, sentAt := resp.GetReceivedAt().AsTime(), resp.GetSentAt().AsTime()
+			if receivedAt.Before(sent.AsTime()) || sentAt.Before(receivedAt) {
+				t.Errorf("timestamps out of order: request=%v received=%v sent=%v", sent.AsTime(), receivedAt, sentAt)
+			}
+		}
+
+		if err := stream.CloseSend(); err != nil {
+			t.Fatalf("CloseSend returned error: %v", err)
+		}
+		if _, err := stream.Recv(); err == nil {
+			t.Error("expected stream to end after CloseSend")
+		}
+	})
+
+	t.Run("nil request_date", func(t *testing.T) {
+		stream, err := client.PingStream(context.Background())
+		if err != nil {
+			t.Fatalf("PingStream returned error: %v", err)
+		}
+		if err := stream.Send(&monitoringpb.Client{Message: "ping"}); err != nil {
+			t.Fatalf("Send returned error: %v", err)
+		}
+		if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument, got %v", err)
+		}
+	})
+}
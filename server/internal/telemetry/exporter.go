@@ -0,0 +1,276 @@
+package telemetry
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+
+	"server/internal/config"
+)
+
+var (
+	exportedSpans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "trace_exporter_spans_total",
+		Help: "Number of spans passed to the trace exporter, by exporter and result (success or failure)",
+	}, []string{"exporter", "result"})
+	exportFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "trace_exporter_failures_total",
+		Help: "Number of failed trace exports, by exporter",
+	}, []string{"exporter"})
+)
+
+// RegisterMetrics registers the telemetry metrics with reg.
+func RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(exportedSpans, exportFailures)
+}
+
+// NewExporter returns the span exporter chosen by cfg.TracesExporterName,
+// or nil for TracesExporterNone. Exports are counted in
+// trace_exporter_spans_total and trace_exporter_failures_total. For OTLP,
+// check fails while the collector cannot be reached: while the gRPC
+// connection is down, or after a failed export over HTTP. It is nil for the
+// other exporters.
+func NewExporter(ctx context.Context, cfg *config.Config) (exp sdktrace.SpanExporter, check func(context.Context) error, err error) {
+	var name string
+	var closer io.Closer
+	switch cfg.TracesExporterName() {
+	case config.TracesExporterNone:
+		log.Println("[TRACES] no trace exporter; spans are propagated but not exported")
+		return nil, nil, nil
+
+	case config.TracesExporterStdout:
+		name = "stdout"
+		w := io.Writer(os.Stdout)
+		if cfg.TracesFile != "" {
+			f, err := os.OpenFile(cfg.TracesFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+			if err != nil {
+				return nil, nil, fmt.Errorf("telemetry: could not open traces file: %w", err)
+			}
+			w, closer = f, f
+		}
+		if exp, err = stdouttrace.New(stdouttrace.WithWriter(w)); err != nil {
+			if closer != nil {
+				_ = closer.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		log.Printf("[TRACES] writing spans as JSON to %s", cmp.Or(cfg.TracesFile, "standard output"))
+
+	case config.TracesExporterOTLP:
+		name = "otlp_grpc"
+		if cfg.OTLPProtocol == config.OTLPProtocolHTTP {
+			name = "otlp_http"
+		}
+		if exp, check, err = NewOTLPExporter(ctx, cfg); err != nil {
+			return nil, nil, err
+		}
+		log.Printf("[TRACES] exporting spans to %s over %s", redactURL(cfg.OTLPCollectorEndpoint),
+			cmp.Or(cfg.OTLPProtocol, config.OTLPProtocolGRPC))
+
+	default:
+		return nil, nil, fmt.Errorf("telemetry: unknown trace exporter %q", cfg.TracesExporter)
+	}
+
+	o := &observedExporter{SpanExporter: exp, name: name, closer: closer}
+	if name == "otlp_http" {
+		check = o.lastError
+	}
+	return o, check, nil
+}
+
+// observedExporter counts the exports of the wrapped exporter and closes
+// the file it writes to, if any, on shutdown.
+type observedExporter struct {
+	sdktrace.SpanExporter
+	name   string
+	closer io.Closer
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func (e *observedExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	result := "success"
+	if err != nil {
+		result = "failure"
+		exportFailures.WithLabelValues(e.name).Inc()
+	}
+	exportedSpans.WithLabelValues(e.name, result).Add(float64(len(spans)))
+
+	e.mu.Lock()
+	e.lastErr = err
+	e.mu.Unlock()
+	return err
+}
+
+func (e *observedExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if e.closer != nil {
+		err = errors.Join(err, e.closer.Close())
+	}
+	return err
+}
+
+// lastError returns the error of the last export, if it failed.
+func (e *observedExporter) lastError(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lastErr != nil {
+		return fmt.Errorf("last %s export failed: %w", e.name, e.lastErr)
+	}
+	return nil
+}
+
+// redactURL masks the password of an endpoint URL for logs.
+func redactURL(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return endpoint
+}
+
+// NewOTLPExporter returns a span exporter for the OTLP settings of cfg.
+// For OTLP/gRPC, check fails while the connection to the collector is in
+// TRANSIENT_FAILURE; OTLP/HTTP has no connection to watch and check is nil.
+func NewOTLPExporter(ctx context.Context, cfg *config.Config) (exp sdktrace.SpanExporter, check func(context.Context) error, err error) {
+	var tlsConfig *tls.Config
+	if cfg.OTLPTLSEnabled() {
+		if tlsConfig, err = collectorTLSConfig(cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cfg.OTLPProtocol == config.OTLPProtocolHTTP {
+		exp, err = newHTTPExporter(ctx, cfg, tlsConfig)
+		return exp, nil, err
+	}
+	return newGRPCExporter(ctx, cfg, tlsConfig)
+}
+
+func newGRPCExporter(ctx context.Context, cfg *config.Config, tlsConfig *tls.Config) (sdktrace.SpanExporter, func(context.Context) error, error) {
+	conn, target, err := dialCollector(cfg, tlsConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Headers and the timeout apply per export, so they work with our own
+	// connection; the connection options of otlptracegrpc would not.
+	opts := []otlptracegrpc.Option{
+		otlptracegrpc.WithGRPCConn(conn),
+		otlptracegrpc.WithHeaders(cfg.OTLPHeaders.Map()),
+	}
+	if cfg.OTLPTimeout > 0 {
+		opts = append(opts, otlptracegrpc.WithTimeout(cfg.OTLPTimeout))
+	}
+	exp, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to create OTLP/gRPC exporter: %w", err)
+	}
+
+	check := func(context.Context) error {
+		if state := conn.GetState(); state == connectivity.TransientFailure {
+			return fmt.Errorf("collector connection to %q is %s", target, state)
+		}
+		return nil
+	}
+	return exp, check, nil
+}
+
+// dialCollector connects to the collector for OTLP/gRPC. It returns the
+// host:port target for messages.
+func dialCollector(cfg *config.Config, tlsConfig *tls.Config) (*grpc.ClientConn, string, error) {
+	// gRPC wants a host:port target; the scheme only selects TLS.
+	target := cfg.OTLPCollectorEndpoint
+	for _, scheme := range []string{"https://", "http://"} {
+		target = strings.TrimPrefix(target, scheme)
+	}
+
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if cfg.OTLPCompression == config.OTLPCompressionGzip {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+	}
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, "", fmt.Errorf("dial OTLP/gRPC endpoint %q: %w", target, err)
+	}
+	return conn, target, nil
+}
+
+func newHTTPExporter(ctx context.Context, cfg *config.Config, tlsConfig *tls.Config) (sdktrace.SpanExporter, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(cfg.OTLPHeaders.Map())}
+	// A URL may carry a path other than /v1/traces; host:port may not.
+	if strings.Contains(cfg.OTLPCollectorEndpoint, "://") {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.OTLPCollectorEndpoint))
+	} else {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.OTLPCollectorEndpoint))
+	}
+	if tlsConfig != nil {
+		opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsConfig))
+	} else {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if cfg.OTLPCompression == config.OTLPCompressionGzip {
+		opts = append(opts, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
+	}
+	if cfg.OTLPTimeout > 0 {
+		opts = append(opts, otlptracehttp.WithTimeout(cfg.OTLPTimeout))
+	}
+
+	exp, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP/HTTP exporter: %w", err)
+	}
+	return exp, nil
+}
+
+// collectorTLSConfig trusts OTLPCAFile, or the system roots, and presents
+// the OTLPCertFile key pair if one is set.
+func collectorTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.OTLPCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.OTLPCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("telemetry: could not read collector CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("telemetry: no certificates found in %s", cfg.OTLPCAFile)
+		}
+	}
+	if cfg.OTLPCertFile != "" {
+		pair, err := tls.LoadX509KeyPair(cfg.OTLPCertFile, cfg.OTLPKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("telemetry: could not load collector client key pair (%s, %s): %w",
+				cfg.OTLPCertFile, cfg.OTLPKeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return tlsConfig, nil
+}
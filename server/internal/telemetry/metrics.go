@@ -0,0 +1,116 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+
+	"server/internal/config"
+)
+
+// NewMetricReaders returns the readers of the meter provider for the
+// MetricsExporter of cfg: a bridge that registers the OpenTelemetry
+// metrics with reg, so that they are served on /metrics next to the
+// Prometheus ones, and a reader that pushes them to the collector. It
+// returns no reader if metrics are turned off.
+func NewMetricReaders(ctx context.Context, cfg *config.Config, reg prometheus.Registerer) ([]sdkmetric.Reader, error) {
+	var readers []sdkmetric.Reader
+	if slices.Contains(cfg.MetricsExporter, config.MetricsExporterPrometheus) {
+		// target_info is registered by RegisterTargetInfo.
+		bridge, err := otelprom.New(otelprom.WithRegisterer(reg), otelprom.WithoutTargetInfo())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Prometheus bridge: %w", err)
+		}
+		readers = append(readers, bridge)
+	}
+	if slices.Contains(cfg.MetricsExporter, config.MetricsExporterOTLP) {
+		exp, err := newOTLPMetricExporter(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, sdkmetric.NewPeriodicReader(exp, sdkmetric.WithInterval(cfg.MetricsExportInterval)))
+		log.Printf("[METRICS] exporting OpenTelemetry metrics to %s every %s", redactURL(cfg.OTLPCollectorEndpoint), cfg.MetricsExportInterval)
+	}
+	return readers, nil
+}
+
+// newOTLPMetricExporter returns a metric exporter for the OTLP settings of
+// cfg, the same as the span exporter uses.
+func newOTLPMetricExporter(ctx context.Context, cfg *config.Config) (sdkmetric.Exporter, error) {
+	var tlsConfig *tls.Config
+	if cfg.OTLPTLSEnabled() {
+		var err error
+		if tlsConfig, err = collectorTLSConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.OTLPProtocol == config.OTLPProtocolHTTP {
+		return newHTTPMetricExporter(ctx, cfg, tlsConfig)
+	}
+	conn, _, err := dialCollector(cfg, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlpmetricgrpc.Option{
+		otlpmetricgrpc.WithGRPCConn(conn),
+		otlpmetricgrpc.WithHeaders(cfg.OTLPHeaders.Map()),
+	}
+	if cfg.OTLPTimeout > 0 {
+		opts = append(opts, otlpmetricgrpc.WithTimeout(cfg.OTLPTimeout))
+	}
+	exp, err := otlpmetricgrpc.New(ctx, opts...)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to create OTLP/gRPC metric exporter: %w", err)
+	}
+	return exp, nil
+}
+
+func newHTTPMetricExporter(ctx context.Context, cfg *config.Config, tlsConfig *tls.Config) (sdkmetric.Exporter, error) {
+	opts := []otlpmetrichttp.Option{otlpmetrichttp.WithHeaders(cfg.OTLPHeaders.Map())}
+	// The path of an endpoint URL is the one for traces; metrics go to
+	// /v1/metrics under the same base path, so /otlp/v1/traces and /otlp
+	// both send them to /otlp/v1/metrics.
+	if strings.Contains(cfg.OTLPCollectorEndpoint, "://") {
+		u, err := url.Parse(cfg.OTLPCollectorEndpoint)
+		if err != nil {
+			// The error would echo a password in the URL.
+			return nil, errors.New("telemetry: otlp_collector_endpoint is not a valid URL")
+		}
+		u.Path = path.Join("/", strings.TrimSuffix(u.Path, "/v1/traces"), "v1/metrics")
+		u.RawPath = ""
+		opts = append(opts, otlpmetrichttp.WithEndpointURL(u.String()))
+	} else {
+		opts = append(opts, otlpmetrichttp.WithEndpoint(cfg.OTLPCollectorEndpoint))
+	}
+	if tlsConfig != nil {
+		opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsConfig))
+	} else {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	}
+	if cfg.OTLPCompression == config.OTLPCompressionGzip {
+		opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+	}
+	if cfg.OTLPTimeout > 0 {
+		opts = append(opts, otlpmetrichttp.WithTimeout(cfg.OTLPTimeout))
+	}
+
+	exp, err := otlpmetrichttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP/HTTP metric exporter: %w", err)
+	}
+	return exp, nil
+}
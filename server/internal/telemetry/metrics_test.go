@@ -0,0 +1,156 @@
+package telemetry
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+
+	"server/internal/config"
+)
+
+// recordDuration records one rpc.server.duration measurement, within a
+// sampled span, with the meter provider built from readers.
+func recordDuration(t *testing.T, readers []sdkmetric.Reader) *sdkmetric.MeterProvider {
+	t.Helper()
+
+	var opts []sdkmetric.Option
+	for _, r := range readers {
+		opts = append(opts, sdkmetric.WithReader(r))
+	}
+	mp := sdkmetric.NewMeterProvider(opts...)
+	duration, err := mp.Meter("test").Float64Histogram("rpc.server.duration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "test")
+	duration.Record(ctx, 12)
+	span.End()
+	return mp
+}
+
+func TestNewMetricReaders_Prometheus(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	readers, err := NewMetricReaders(context.Background(), &config.Config{
+		MetricsExporter: []string{config.MetricsExporterPrometheus},
+	}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordDuration(t, readers)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "target_info" {
+			t.Error("the bridge registered its own target_info")
+		}
+		// Dots are escaped to underscores, or not, when the metrics are
+		// served, depending on what the scraper accepts.
+		if family.GetName() != "rpc.server.duration" {
+			continue
+		}
+		h := family.GetMetric()[0].GetHistogram()
+		if h.GetSampleCount() != 1 || h.GetSampleSum() != 12 {
+			t.Errorf("got %d samples summing to %v, want 1 of 12", h.GetSampleCount(), h.GetSampleSum())
+		}
+		var exemplars int
+		for _, b := range h.GetBucket() {
+			if b.GetExemplar() != nil {
+				exemplars++
+			}
+		}
+		if exemplars != 1 {
+			t.Errorf("got %d exemplars, want the one of the sampled span", exemplars)
+		}
+		return
+	}
+	t.Fatalf("rpc.server.duration not found in %d metric families", len(families))
+}
+
+type metricsCollector struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	exports chan *colmetricpb.ExportMetricsServiceRequest
+}
+
+func (c *metricsCollector) Export(_ context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	c.exports <- req
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestNewMetricReaders_OTLP(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	collector := &metricsCollector{exports: make(chan *colmetricpb.ExportMetricsServiceRequest, 1)}
+	srv := grpc.NewServer()
+	colmetricpb.RegisterMetricsServiceServer(srv, collector)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	// The path of an OTLP/HTTP URL is for traces; metrics go to
+	// /v1/metrics under its base path.
+	paths := make(chan string, 1)
+	httpCollector := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+	}))
+	defer httpCollector.Close()
+
+	tests := []struct {
+		name     string
+		cfg      config.Config
+		wantPath string
+	}{
+		{name: "grpc", cfg: config.Config{OTLPCollectorEndpoint: lis.Addr().String()}},
+		{name: "http", cfg: config.Config{OTLPCollectorEndpoint: httpCollector.URL, OTLPProtocol: config.OTLPProtocolHTTP}, wantPath: "/v1/metrics"},
+		{name: "http traces path", cfg: config.Config{OTLPCollectorEndpoint: httpCollector.URL + "/otlp/v1/traces", OTLPProtocol: config.OTLPProtocolHTTP}, wantPath: "/otlp/v1/metrics"},
+		{name: "http path prefix", cfg: config.Config{OTLPCollectorEndpoint: httpCollector.URL + "/otlp", OTLPProtocol: config.OTLPProtocolHTTP}, wantPath: "/otlp/v1/metrics"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.MetricsExporter = []string{config.MetricsExporterOTLP}
+			tc.cfg.MetricsExportInterval = config.Default().MetricsExportInterval
+			readers, err := NewMetricReaders(context.Background(), &tc.cfg, prometheus.NewRegistry())
+			if err != nil {
+				t.Fatal(err)
+			}
+			mp := recordDuration(t, readers)
+			if err := mp.Shutdown(context.Background()); err != nil {
+				t.Fatalf("failed to export metrics: %v", err)
+			}
+
+			if tc.cfg.OTLPProtocol == config.OTLPProtocolHTTP {
+				if path := <-paths; path != tc.wantPath {
+					t.Errorf("metrics were sent to %s, want %s", path, tc.wantPath)
+				}
+				return
+			}
+			req := <-collector.exports
+			if got := req.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0].GetName(); got != "rpc.server.duration" {
+				t.Errorf("exported metric %q, want rpc.server.duration", got)
+			}
+		})
+	}
+}
+
+func TestNewMetricReaders_None(t *testing.T) {
+	for _, exporters := range [][]string{nil, {config.MetricsExporterNone}} {
+		readers, err := NewMetricReaders(context.Background(), &config.Config{MetricsExporter: exporters}, prometheus.NewRegistry())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(readers) != 0 {
+			t.Errorf("metrics_exporter %v: got %d readers, want none", exporters, len(readers))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package telemetry
+
+import (
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewSpanProcessor batches spans to exp. Besides sampled spans, it
+// exports the spans recorded for the errors sampler override whose call
+// failed, marked as sampled.
+func NewSpanProcessor(exp sdktrace.SpanExporter) sdktrace.SpanProcessor {
+	return &errorSpanProcessor{SpanProcessor: sdktrace.NewBatchSpanProcessor(exp)}
+}
+
+type errorSpanProcessor struct {
+	sdktrace.SpanProcessor
+}
+
+func (p *errorSpanProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() {
+		if !failed(s) {
+			return
+		}
+		s = sampledSpan{s}
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// failed reports whether the span ended with an error status or, for an
+// RPC, a gRPC status other than OK. otelgrpc leaves the span status unset
+// for server errors that are the caller's fault, such as InvalidArgument.
+func failed(s sdktrace.ReadOnlySpan) bool {
+	if s.Status().Code == codes.Error {
+		return true
+	}
+	for _, attr := range s.Attributes() {
+		if attr.Key == semconv.RPCGRPCStatusCodeKey {
+			return attr.Value.AsInt64() != 0
+		}
+	}
+	return false
+}
+
+// sampledSpan sets the sampled flag of a span recorded without it.
+type sampledSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() trace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
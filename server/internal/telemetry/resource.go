@@ -0,0 +1,111 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+
+	"server/internal/config"
+)
+
+// NewResource describes this process to tracing backends: service.name,
+// service.version from the build info, service.instance.id,
+// deployment.environment and what the host, OS, process and container
+// detectors find. OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME override
+// the detected attributes; DeploymentEnvironment and ServiceInstanceID, if
+// set, override those in turn.
+func NewResource(ctx context.Context, cfg *config.Config, serviceName string) (*resource.Resource, error) {
+	var explicit []attribute.KeyValue
+	if cfg.DeploymentEnvironment != "" {
+		explicit = append(explicit, semconv.DeploymentEnvironmentKey.String(cfg.DeploymentEnvironment))
+	}
+	if cfg.ServiceInstanceID != "" {
+		explicit = append(explicit, semconv.ServiceInstanceIDKey.String(cfg.ServiceInstanceID))
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithOSType(),
+		resource.WithProcessPID(),
+		resource.WithProcessRuntimeVersion(),
+		resource.WithContainer(),
+		resource.WithAttributes(
+			semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersionKey.String(buildVersion()),
+			semconv.ServiceInstanceIDKey.String(uuid.NewString()),
+		),
+		resource.WithFromEnv(),
+		resource.WithAttributes(explicit...),
+	)
+	if errors.Is(err, resource.ErrPartialResource) {
+		// A detector failed, such as the container ID outside a
+		// container; keep what the others found.
+		log.Printf("[TRACES] some resource attributes are missing: %v", err)
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+	return res, nil
+}
+
+// buildVersion returns the module version the binary was built from, or
+// the VCS revision for a build of a working tree.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	return "(devel)"
+}
+
+// RegisterTargetInfo registers target_info, a gauge with the value 1 and
+// the attributes of res as labels, so that Prometheus series can be joined
+// with the traces of the same process. Label names have the dots of the
+// attribute names replaced by underscores, as in the OpenTelemetry
+// Prometheus exporter.
+func RegisterTargetInfo(reg prometheus.Registerer, res *resource.Resource) {
+	labels := make(prometheus.Labels, res.Len())
+	for _, attr := range res.Attributes() {
+		labels[labelName(string(attr.Key))] = attr.Value.Emit()
+	}
+	targetInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "target_info",
+		Help:        "Target metadata: the OpenTelemetry resource attributes of this process",
+		ConstLabels: labels,
+	})
+	targetInfo.Set(1)
+	reg.MustRegister(targetInfo)
+}
+
+// labelName maps an attribute name to a valid Prometheus label name.
+func labelName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, key)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "key_" + name
+	}
+	return name
+}